@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// posted messages are forgotten after this long even if their alerts never resolve
+const postedMessageRetention = 7 * 24 * time.Hour
+
+// postedMessage is a message posted with the bot token, it is kept to update
+// the message in place once its alerts resolve.
+type postedMessage struct {
+	// mu serializes updates of the message, alerts only changes while it is held
+	mu        sync.Mutex
+	channelID string
+	// channel is the name the message was posted to, layouts are configured per name
+	channel   string
+	digest    bool
+	timestamp string
+	msg       GrafanaMsg
+	alerts    []Alert
+	postedAt  time.Time
+}
+
+// messageStore maps alert keys to the messages the alerts were posted in.
+type messageStore struct {
+	mu       sync.Mutex
+	messages map[string]*postedMessage
+}
+
+func newMessageStore() *messageStore {
+	return &messageStore{messages: map[string]*postedMessage{}}
+}
+
+func (s *messageStore) put(posted *postedMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, m := range s.messages {
+		if time.Since(m.postedAt) > postedMessageRetention {
+			delete(s.messages, key)
+		}
+	}
+	for _, alert := range posted.alerts {
+		s.messages[alertKey(alert)] = posted
+	}
+}
+
+func (s *messageStore) get(key string) (*postedMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	posted, ok := s.messages[key]
+	return posted, ok
+}
+
+// resolve replaces the alerts of the posted message and forgets the message
+// once none of its alerts is firing anymore.
+func (s *messageStore) resolve(posted *postedMessage, alerts []Alert) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	posted.alerts = alerts
+	for _, alert := range alerts {
+		if alert.Status != "resolved" {
+			return
+		}
+	}
+	s.removeLocked(posted)
+}
+
+func (s *messageStore) remove(posted *postedMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeLocked(posted)
+}
+
+func (s *messageStore) removeLocked(posted *postedMessage) {
+	for key, m := range s.messages {
+		if m == posted {
+			delete(s.messages, key)
+		}
+	}
+}
+
+func messageOptions(msg *SlackMsg) []slack.MsgOption {
+	options := []slack.MsgOption{
+		slack.MsgOptionText(msg.Text, false),
+		slack.MsgOptionAttachments(msg.Attachments...),
+		slack.MsgOptionUsername(msg.Username),
+	}
+	if msg.IconURL != "" {
+		options = append(options, slack.MsgOptionIconURL(msg.IconURL))
+	} else if msg.IconEmoji != "" {
+		options = append(options, slack.MsgOptionIconEmoji(msg.IconEmoji))
+	}
+	if msg.Blocks != nil {
+		options = append(options, slack.MsgOptionBlocks(msg.Blocks.BlockSet...))
+	}
+	if !msg.UnfurlLinks {
+		options = append(options, slack.MsgOptionDisableLinkUnfurl())
+	}
+	if !msg.UnfurlMedia {
+		options = append(options, slack.MsgOptionDisableMediaUnfurl())
+	}
+	return options
+}
+
+func (h *Handler) postWithToken(ctx context.Context, grafanaMsg GrafanaMsg, msg *SlackMsg) error {
+	if h.ephemeral(msg.Alerts) {
+		// ephemeral messages can be neither updated, deleted nor threaded
+		_, err := h.slackClient.PostEphemeralContext(ctx, msg.Channel, h.config.EphemeralUser, messageOptions(msg)...)
+		return err
+	}
+	channelID, timestamp, err := h.slackClient.PostMessageContext(ctx, msg.Channel, messageOptions(msg)...)
+	if err != nil {
+		return err
+	}
+	h.uploadImages(ctx, channelID, timestamp, msg.Alerts)
+
+	posted := &postedMessage{
+		channelID: channelID,
+		channel:   msg.Channel,
+		digest:    msg.Digest,
+		timestamp: timestamp,
+		msg:       grafanaMsg,
+		alerts:    msg.Alerts,
+		postedAt:  time.Now(),
+	}
+	posted.msg.Alerts = nil
+	if h.config.DeleteAfter > 0 && h.expiring(msg.Alerts) {
+		time.AfterFunc(h.config.DeleteAfter, func() {
+			h.deleteMessage(posted)
+		})
+	}
+	firing := false
+	for _, alert := range msg.Alerts {
+		firing = firing || alert.Status != "resolved"
+	}
+	// summaries are not updated, rendering every alert of one in full may
+	// exceed the block limit of slack
+	if firing && h.config.UpdateResolved && !msg.Summary {
+		h.messages.put(posted)
+	}
+	return nil
+}
+
+// uploadImages posts embedded alert images into the thread of the message,
+// failures are only logged since the message itself is already delivered.
+func (h *Handler) uploadImages(ctx context.Context, channelID string, timestamp string, alerts []Alert) {
+	for _, alert := range alerts {
+		if alert.EmbeddedImage == "" {
+			continue
+		}
+		image, err := base64.StdEncoding.DecodeString(alert.EmbeddedImage)
+		if err != nil {
+			slog.Error("failed to decode embedded image", "err", err, "fingerprint", alert.Fingerprint)
+			continue
+		}
+		_, err = h.slackClient.UploadFileV2Context(ctx, slack.UploadFileV2Parameters{
+			Reader:          bytes.NewReader(image),
+			FileSize:        len(image),
+			Filename:        alertKey(alert) + ".png",
+			Title:           alertSummary(alert),
+			Channel:         channelID,
+			ThreadTimestamp: timestamp,
+		})
+		if err != nil {
+			slog.Error("failed to upload embedded image", "err", err, "fingerprint", alert.Fingerprint)
+		}
+	}
+}
+
+// expiring reports whether every alert has one of the severities whose
+// messages are deleted after a while.
+func (h *Handler) expiring(alerts []Alert) bool {
+	return allHaveSeverity(alerts, h.config.DeleteSeverities)
+}
+
+// ephemeral reports whether every alert has one of the severities whose
+// messages are only shown to the ephemeral user.
+func (h *Handler) ephemeral(alerts []Alert) bool {
+	return h.config.EphemeralUser != "" && allHaveSeverity(alerts, h.config.EphemeralSeverities)
+}
+
+func allHaveSeverity(alerts []Alert, severities []string) bool {
+	for _, alert := range alerts {
+		if !slices.Contains(severities, alert.Labels["severity"]) {
+			return false
+		}
+	}
+	return len(alerts) > 0
+}
+
+func (h *Handler) deleteMessage(posted *postedMessage) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if _, _, err := h.slackClient.DeleteMessageContext(ctx, posted.channelID, posted.timestamp); err != nil {
+		slog.Error("failed to delete expired slack message", "err", err, "channel", posted.channelID, "ts", posted.timestamp)
+		return
+	}
+	h.messages.remove(posted)
+}
+
+// updateResolvedMessages edits previously posted messages in place for the
+// resolved alerts they contain and returns the alerts that still need a new
+// message, either because they are unknown or because the update failed.
+func (h *Handler) updateResolvedMessages(ctx context.Context, msg GrafanaMsg) []Alert {
+	var remaining []Alert
+	updates := map[*postedMessage][]Alert{}
+	for _, alert := range msg.Alerts {
+		if alert.Status == "resolved" {
+			if posted, ok := h.messages.get(alertKey(alert)); ok {
+				updates[posted] = append(updates[posted], alert)
+				continue
+			}
+		}
+		remaining = append(remaining, alert)
+	}
+	for posted, resolved := range updates {
+		if err := h.updateMessage(ctx, posted, resolved); err != nil {
+			slog.Error("failed to update slack message, posting a new one", "err", err, "channel", posted.channelID, "ts", posted.timestamp)
+			remaining = append(remaining, resolved...)
+		}
+	}
+	return remaining
+}
+
+func (h *Handler) updateMessage(ctx context.Context, posted *postedMessage, resolved []Alert) error {
+	// notifications resolving different alerts of the message may arrive at
+	// once, merging them one at a time keeps every resolution
+	posted.mu.Lock()
+	defer posted.mu.Unlock()
+	alerts := make([]Alert, len(posted.alerts))
+	copy(alerts, posted.alerts)
+	for i, alert := range alerts {
+		for _, r := range resolved {
+			if alertKey(alert) == alertKey(r) {
+				alerts[i] = r
+			}
+		}
+	}
+	var msg SlackMsg
+	if posted.digest {
+		msg = h.buildDigestMessage(posted.msg, alerts, posted.channel)
+	} else {
+		msg = h.buildMessage(posted.msg, alerts, posted.channel)
+	}
+	h.addMentions(&msg)
+	if err := h.throttle(ctx); err != nil {
+		return err
+	}
+	ctx, cancel := h.slackContext(ctx)
+	defer cancel()
+	if _, _, _, err := h.slackClient.UpdateMessageContext(ctx, posted.channelID, posted.timestamp, messageOptions(&msg)...); err != nil {
+		h.backOff(err)
+		return err
+	}
+	h.messages.resolve(posted, alerts)
+	return nil
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+type slackCall struct {
+	method string
+	form   url.Values
+}
+
+// fakeSlack records the Web API calls of a slack client, every call succeeds
+// after the configured delay.
+type fakeSlack struct {
+	*httptest.Server
+	mu    sync.Mutex
+	calls []slackCall
+	delay time.Duration
+}
+
+func newFakeSlack(t *testing.T) *fakeSlack {
+	f := &fakeSlack{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		f.mu.Lock()
+		f.calls = append(f.calls, slackCall{method: strings.TrimPrefix(r.URL.Path, "/"), form: r.PostForm})
+		n := len(f.calls)
+		delay := f.delay
+		f.mu.Unlock()
+		time.Sleep(delay)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"ok":true,"channel":"C0123","ts":"1700000000.%06d","message_ts":"1700000000.%06d"}`, n, n)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeSlack) client() *slack.Client {
+	return slack.New("xoxb-test", slack.OptionAPIURL(f.URL+"/"))
+}
+
+func (f *fakeSlack) callsOf(method string) []slackCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var calls []slackCall
+	for _, call := range f.calls {
+		if call.method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// botHandler returns a handler calling the slack api of a fake with a bot token.
+func botHandler(t *testing.T, config Config) (*Handler, *fakeSlack) {
+	config.SlackBotToken = "xoxb-test"
+	fake := newFakeSlack(t)
+	h := newHandler(config)
+	h.slackClient = fake.client()
+	return h, fake
+}
+
+func resolvedAlert(alert Alert) Alert {
+	alert.Status = "resolved"
+	alert.EndsAt = alert.StartsAt.Add(time.Hour)
+	return alert
+}
+
+func TestUpdateResolvedMessagesKeepsConcurrentResolutions(t *testing.T) {
+	h, fake := botHandler(t, testConfig())
+	first, second := testAlert("firing", "a"), testAlert("firing", "b")
+	msg := h.buildMessage(GrafanaMsg{}, []Alert{first, second}, "alerts")
+	if err := h.postWithToken(context.Background(), GrafanaMsg{}, &msg); err != nil {
+		t.Fatal(err)
+	}
+
+	// slow updates make both notifications merge into the message at once
+	fake.mu.Lock()
+	fake.delay = 50 * time.Millisecond
+	fake.mu.Unlock()
+	var wg sync.WaitGroup
+	for _, alert := range []Alert{first, second} {
+		wg.Add(1)
+		go func(alert Alert) {
+			defer wg.Done()
+			remaining := h.updateResolvedMessages(context.Background(), GrafanaMsg{Alerts: []Alert{resolvedAlert(alert)}})
+			if len(remaining) != 0 {
+				t.Errorf("remaining alerts = %v, want the message to be updated", remaining)
+			}
+		}(alert)
+	}
+	wg.Wait()
+
+	updates := fake.callsOf("chat.update")
+	if len(updates) != 2 {
+		t.Fatalf("chat.update calls = %d, want 2", len(updates))
+	}
+	if last := updates[1].form.Get("blocks"); strings.Contains(last, ":sos:") {
+		t.Errorf("last update still renders a firing alert: %s", last)
+	}
+	for _, alert := range []Alert{first, second} {
+		if _, ok := h.messages.get(alertKey(alert)); ok {
+			t.Errorf("message of resolved alert %s is still stored", alert.Labels["alertname"])
+		}
+	}
+}
+
+func TestUpdateMessageKeepsChannelLayout(t *testing.T) {
+	config := testConfig()
+	config.ChannelLayouts = StringMap{"#ops": layoutCompact}
+	h, fake := botHandler(t, config)
+	alert := testAlert("firing", "a")
+	msg := h.buildMessage(GrafanaMsg{}, []Alert{alert}, "#ops")
+	if err := h.postWithToken(context.Background(), GrafanaMsg{}, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if remaining := h.updateResolvedMessages(context.Background(), GrafanaMsg{Alerts: []Alert{resolvedAlert(alert)}}); len(remaining) != 0 {
+		t.Fatalf("remaining alerts = %v, want the message to be updated", remaining)
+	}
+
+	updates := fake.callsOf("chat.update")
+	if len(updates) != 1 {
+		t.Fatalf("chat.update calls = %d, want 1", len(updates))
+	}
+	if blocks := updates[0].form.Get("blocks"); strings.Contains(blocks, "alertname=a") {
+		t.Errorf("update of a compact channel renders labels: %s", blocks)
+	}
+}
+
+func TestUpdateMessageKeepsDigest(t *testing.T) {
+	config := testConfig()
+	config.DigestLine = true
+	h, fake := botHandler(t, config)
+	first, second := testAlert("firing", "a"), testAlert("firing", "b")
+	msg := h.buildDigestMessage(GrafanaMsg{}, []Alert{first, second}, "alerts")
+	if err := h.postWithToken(context.Background(), GrafanaMsg{}, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if remaining := h.updateResolvedMessages(context.Background(), GrafanaMsg{Alerts: []Alert{resolvedAlert(first)}}); len(remaining) != 0 {
+		t.Fatalf("remaining alerts = %v, want the message to be updated", remaining)
+	}
+
+	updates := fake.callsOf("chat.update")
+	if len(updates) != 1 {
+		t.Fatalf("chat.update calls = %d, want 1", len(updates))
+	}
+	if blocks := updates[0].form.Get("blocks"); blocks != "" {
+		t.Errorf("digest update renders blocks: %s", blocks)
+	}
+	if text := updates[0].form.Get("text"); len(strings.Split(text, "\n")) != 2 {
+		t.Errorf("digest update text = %q, want one line per alert", text)
+	}
+}
+
+func TestSummaryMessagesAreNotUpdated(t *testing.T) {
+	config := testConfig()
+	config.SummaryThreshold = 1
+	h, fake := botHandler(t, config)
+	first, second := testAlert("firing", "a"), testAlert("firing", "b")
+	msg := h.buildSummaryMessage(GrafanaMsg{}, "firing", []Alert{first, second}, "alerts")
+	if err := h.postWithToken(context.Background(), GrafanaMsg{}, &msg); err != nil {
+		t.Fatal(err)
+	}
+	resolved := resolvedAlert(first)
+	remaining := h.updateResolvedMessages(context.Background(), GrafanaMsg{Alerts: []Alert{resolved}})
+	if len(remaining) != 1 || alertKey(remaining[0]) != alertKey(resolved) {
+		t.Errorf("remaining alerts = %v, want the resolved alert to be posted anew", remaining)
+	}
+	if updates := fake.callsOf("chat.update"); len(updates) != 0 {
+		t.Errorf("chat.update calls = %d, want none", len(updates))
+	}
+}
+
+func TestPostWithTokenSchedulesDeletion(t *testing.T) {
+	tests := []struct {
+		name       string
+		severity   string
+		wantDelete bool
+	}{
+		{name: "configured severity", severity: "info", wantDelete: true},
+		{name: "other severity", severity: "critical", wantDelete: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			config.DeleteAfter = 20 * time.Millisecond
+			config.DeleteSeverities = StringList{"info"}
+			h, fake := botHandler(t, config)
+			alert := testAlert("firing", "a")
+			alert.Labels["severity"] = tt.severity
+			msg := h.buildMessage(GrafanaMsg{}, []Alert{alert}, "alerts")
+			if err := h.postWithToken(context.Background(), GrafanaMsg{}, &msg); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(200 * time.Millisecond)
+
+			deletes := fake.callsOf("chat.delete")
+			if got := len(deletes) == 1; got != tt.wantDelete {
+				t.Fatalf("chat.delete calls = %d, want deletion %v", len(deletes), tt.wantDelete)
+			}
+			if !tt.wantDelete {
+				return
+			}
+			if ts := deletes[0].form.Get("ts"); ts != "1700000000.000001" {
+				t.Errorf("deleted ts = %s, want the posted message", ts)
+			}
+			if _, ok := h.messages.get(alertKey(alert)); ok {
+				t.Error("deleted message is still stored")
+			}
+		})
+	}
+}
+
+func TestResolvedAlertUpdatesMessageOfEarlierNotification(t *testing.T) {
+	h, fake := botHandler(t, testConfig())
+	firing := testAlert("firing", "a")
+	firing.Fingerprint = "fp-a"
+	other := testAlert("firing", "b")
+	other.Fingerprint = "fp-b"
+	if w := notify(t, h, "/", GrafanaMsg{Alerts: []Alert{firing, other}}); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if w := notify(t, h, "/", GrafanaMsg{Alerts: []Alert{resolvedAlert(firing)}}); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+
+	if posts := fake.callsOf("chat.postMessage"); len(posts) != 1 {
+		t.Errorf("chat.postMessage calls = %d, want the resolution to update the message", len(posts))
+	}
+	updates := fake.callsOf("chat.update")
+	if len(updates) != 1 {
+		t.Fatalf("chat.update calls = %d, want 1", len(updates))
+	}
+	if ts := updates[0].form.Get("ts"); ts != "1700000000.000001" {
+		t.Errorf("updated ts = %s, want the message of the first notification", ts)
+	}
+	blocks := updates[0].form.Get("blocks")
+	if !strings.Contains(blocks, ":large_green_circle: a") || !strings.Contains(blocks, ":sos: b") {
+		t.Errorf("update = %s, want a resolved and b still firing", blocks)
+	}
+
+	// the message stays known until its last alert resolves
+	if _, ok := h.messages.get("fp-b"); !ok {
+		t.Error("message with a firing alert is forgotten")
+	}
+	notify(t, h, "/", GrafanaMsg{Alerts: []Alert{resolvedAlert(other)}})
+	if updates := fake.callsOf("chat.update"); len(updates) != 2 {
+		t.Errorf("chat.update calls = %d, want 2", len(updates))
+	}
+	if _, ok := h.messages.get("fp-b"); ok {
+		t.Error("message of resolved alerts is still stored")
+	}
+}
+
+func TestPostWithTokenEphemeral(t *testing.T) {
+	tests := []struct {
+		name          string
+		severity      string
+		wantEphemeral bool
+	}{
+		{name: "ephemeral severity", severity: "info", wantEphemeral: true},
+		{name: "channel severity", severity: "critical", wantEphemeral: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			config.EphemeralUser = "U999"
+			config.EphemeralSeverities = StringList{"info"}
+			h, fake := botHandler(t, config)
+			alert := testAlert("firing", "a")
+			alert.Labels["severity"] = tt.severity
+			msg := h.buildMessage(GrafanaMsg{}, []Alert{alert}, "#alerts")
+			if err := h.postWithToken(context.Background(), GrafanaMsg{}, &msg); err != nil {
+				t.Fatal(err)
+			}
+
+			ephemeral, posts := fake.callsOf("chat.postEphemeral"), fake.callsOf("chat.postMessage")
+			if got := len(ephemeral) == 1 && len(posts) == 0; got != tt.wantEphemeral {
+				t.Fatalf("ephemeral posts = %d, channel posts = %d, want ephemeral %v", len(ephemeral), len(posts), tt.wantEphemeral)
+			}
+			if !tt.wantEphemeral {
+				return
+			}
+			if user, channel := ephemeral[0].form.Get("user"), ephemeral[0].form.Get("channel"); user != "U999" || channel != "#alerts" {
+				t.Errorf("ephemeral post to %s in %s, want U999 in #alerts", user, channel)
+			}
+			// ephemeral messages cannot be updated once the alert resolves
+			if _, ok := h.messages.get(alertKey(alert)); ok {
+				t.Error("ephemeral message is stored for updates")
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// channelSources resolve a slack channel for a request, an empty result means
+// the source has no opinion and the next one in the precedence list is asked:
+//
+//	query    - 'channel' query param of the webhook request
+//	label    - value of the -channel-label label shared by all alerts
+//	route    - channel of the first route matching the common labels
+//	severity - channel of the common severity label in -severity-map
+//	receiver - name of the grafana contact point
+//	org      - channel mapped to the grafana org id by -org-channel-map
+var channelSources = map[string]func(h *Handler, r *http.Request, msg GrafanaMsg) string{
+	"query": func(h *Handler, r *http.Request, msg GrafanaMsg) string {
+		return r.URL.Query().Get("channel")
+	},
+	"label": func(h *Handler, r *http.Request, msg GrafanaMsg) string {
+		if h.config.ChannelLabel == "" {
+			return ""
+		}
+		return msg.CommonLabels[h.config.ChannelLabel]
+	},
+	"route": func(h *Handler, r *http.Request, msg GrafanaMsg) string {
+		for _, route := range h.config.Routes {
+			if route.matches(msg.CommonLabels) {
+				return route.Channel
+			}
+		}
+		return ""
+	},
+	"severity": func(h *Handler, r *http.Request, msg GrafanaMsg) string {
+		return h.config.SeverityMap[msg.CommonLabels["severity"]].Channel
+	},
+	"receiver": func(h *Handler, r *http.Request, msg GrafanaMsg) string {
+		return msg.Receiver
+	},
+	"org": func(h *Handler, r *http.Request, msg GrafanaMsg) string {
+		return h.config.OrgChannelMap[strconv.FormatInt(msg.OrgID, 10)]
+	},
+}
+
+func (r Route) matches(labels map[string]string) bool {
+	for _, matcher := range r.Matchers {
+		value, ok := labels[matcher.Name]
+		if !ok {
+			return false
+		}
+		if matcher.re != nil {
+			if !matcher.re.MatchString(value) {
+				return false
+			}
+		} else if value != matcher.Value {
+			return false
+		}
+	}
+	return true
+}
+
+func validateChannelPrecedence(precedence []string) error {
+	for _, source := range precedence {
+		if _, ok := channelSources[source]; !ok {
+			return fmt.Errorf("unknown channel source '%s'", source)
+		}
+	}
+	return nil
+}
+
+func (h *Handler) resolveChannel(r *http.Request, msg GrafanaMsg) (string, string) {
+	for _, source := range h.config.ChannelPrecedence {
+		if channel := channelSources[source](h, r, msg); channel != "" {
+			return channel, source
+		}
+	}
+	return h.config.DefaultChannel, "default"
+}
+
+// resolveWebhook picks the webhook named by the 'webhook' query param,
+// falling back to -webhook-url when the param is missing.
+func (h *Handler) resolveWebhook(r *http.Request) (string, error) {
+	name := r.URL.Query().Get("webhook")
+	if name == "" {
+		if h.config.WebhookUrl == "" && h.slackClient == nil && !h.config.DryRun {
+			return "", fmt.Errorf("webhook query param is required when webhook-url is not set")
+		}
+		return h.config.WebhookUrl, nil
+	}
+	webhookUrl, ok := h.config.WebhookUrls[name]
+	if !ok {
+		return "", fmt.Errorf("unknown webhook '%s'", name)
+	}
+	return webhookUrl, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResolveChannelPrecedence(t *testing.T) {
+	config := testConfig()
+	config.DefaultChannel = "#default"
+	config.ChannelLabel = "channel"
+	config.Routes = Routes{{Matchers: []RouteMatcher{{Name: "team", Value: "db"}}, Channel: "#route"}}
+	config.SeverityMap = SeverityMap{"critical": {Channel: "#severity"}}
+	config.OrgChannelMap = StringMap{"2": "#org"}
+	msg := GrafanaMsg{
+		Receiver:     "#receiver",
+		OrgID:        2,
+		CommonLabels: map[string]string{"channel": "#label", "team": "db", "severity": "critical"},
+	}
+	tests := []struct {
+		name       string
+		precedence StringList
+		target     string
+		msg        GrafanaMsg
+		want       string
+		wantSource string
+	}{
+		{
+			name:       "query wins by default",
+			precedence: StringList{"query", "label", "route", "severity", "org"},
+			target:     "/?channel=%23query",
+			msg:        msg,
+			want:       "#query",
+			wantSource: "query",
+		},
+		{
+			name:       "next source without query param",
+			precedence: StringList{"query", "label", "route", "severity", "org"},
+			target:     "/",
+			msg:        msg,
+			want:       "#label",
+			wantSource: "label",
+		},
+		{
+			name:       "reordered precedence",
+			precedence: StringList{"org", "route", "query"},
+			target:     "/?channel=%23query",
+			msg:        msg,
+			want:       "#org",
+			wantSource: "org",
+		},
+		{
+			name:       "sources without opinion are skipped",
+			precedence: StringList{"label", "route", "severity", "receiver"},
+			target:     "/",
+			msg:        GrafanaMsg{Receiver: "#receiver", CommonLabels: map[string]string{"severity": "critical"}},
+			want:       "#severity",
+			wantSource: "severity",
+		},
+		{
+			name:       "receiver",
+			precedence: StringList{"receiver", "org"},
+			target:     "/",
+			msg:        msg,
+			want:       "#receiver",
+			wantSource: "receiver",
+		},
+		{
+			name:       "default channel",
+			precedence: StringList{"query", "label"},
+			target:     "/",
+			msg:        GrafanaMsg{},
+			want:       "#default",
+			wantSource: "default",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := config
+			config.ChannelPrecedence = tt.precedence
+			channel, source := newHandler(config).resolveChannel(httptest.NewRequest("POST", tt.target, nil), tt.msg)
+			if channel != tt.want || source != tt.wantSource {
+				t.Errorf("channel = %s from %s, want %s from %s", channel, source, tt.want, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestValidateChannelPrecedence(t *testing.T) {
+	if err := validateChannelPrecedence([]string{"query", "receiver", "org"}); err != nil {
+		t.Errorf("valid precedence failed: %v", err)
+	}
+	if err := validateChannelPrecedence([]string{"query", "team"}); err == nil {
+		t.Error("want an error for an unknown channel source")
+	}
+}
+
+func TestWebhookQueryParam(t *testing.T) {
+	ops := newFakeWebhook(t)
+	config := testConfig()
+	config.WebhookUrls = StringMap{"ops": ops.URL}
+	h, fallback := webhookHandler(t, config)
+	msg := GrafanaMsg{Alerts: []Alert{testAlert("firing", "a")}}
+
+	if w := notify(t, h, "/?webhook=ops", msg); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	w := notify(t, h, "/?webhook=dev", msg)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "unknown webhook 'dev'") {
+		t.Errorf("status = %d with %q, want 400 for an unknown webhook", w.Code, w.Body.String())
+	}
+	if len(ops.posted()) != 1 || len(fallback.posted()) != 0 {
+		t.Errorf("ops got %d and webhook-url %d messages, want only the named webhook posted to", len(ops.posted()), len(fallback.posted()))
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	ConfigWatchInterval         time.Duration `yaml:"configWatchInterval"`
+	LogFormat                   string        `yaml:"logFormat"`
+	LogLevel                    string        `yaml:"logLevel"`
+	ListenAddress               string        `yaml:"listenAddress"`
+	PathPrefix                  string        `yaml:"pathPrefix"`
+	TLSCert                     string        `yaml:"tlsCert"`
+	TLSKey                      string        `yaml:"tlsKey"`
+	WebhookUrl                  string        `yaml:"webhookUrl"`
+	WebhookUrls                 StringMap     `yaml:"webhookUrls"`
+	SinkUrls                    StringList    `yaml:"sinkUrls"`
+	SlackBotToken               string        `yaml:"slackBotToken"`
+	UpdateResolved              bool          `yaml:"updateResolved"`
+	DeleteAfter                 time.Duration `yaml:"deleteAfter"`
+	DeleteSeverities            StringList    `yaml:"deleteSeverities"`
+	EphemeralSeverities         StringList    `yaml:"ephemeralSeverities"`
+	EphemeralUser               string        `yaml:"ephemeralUser"`
+	Username                    string        `yaml:"username"`
+	IconEmoji                   string        `yaml:"iconEmoji"`
+	IconURL                     string        `yaml:"iconUrl"`
+	UsernameFromReceiver        bool          `yaml:"usernameFromReceiver"`
+	DefaultChannel              string        `yaml:"defaultChannel"`
+	ChannelPrecedence           StringList    `yaml:"channelPrecedence"`
+	RoutesFile                  string        `yaml:"routesFile"`
+	Routes                      Routes        `yaml:"routes"`
+	OrgChannelMap               StringMap     `yaml:"orgChannelMap"`
+	ChannelLabel                string        `yaml:"channelLabel"`
+	IncludeLabels               LabelMatchers `yaml:"includeLabels"`
+	ExcludeLabels               LabelMatchers `yaml:"excludeLabels"`
+	DropLabels                  LabelMatchers `yaml:"dropLabels"`
+	StatusPolicy                string        `yaml:"statusPolicy"`
+	GrafanaAlertSource          bool          `yaml:"grafanaAlertSource"`
+	GrafanaUrl                  string        `yaml:"grafanaUrl"`
+	GrafanaApiToken             string        `yaml:"grafanaApiToken"`
+	DisableGrafanaSilenceButton bool          `yaml:"grafanaSilenceButton"`
+	AlertmanagerName            string        `yaml:"alertmanagerName"`
+	ExploreDatasource           string        `yaml:"exploreDatasource"`
+	ExploreDatasourceUid        string        `yaml:"exploreDatasourceUid"`
+	ImageAnnotation             string        `yaml:"imageAnnotation"`
+	TeamLabelKey                string        `yaml:"teamLabelKey"`
+	LabelAnnotations            StringList    `yaml:"labelAnnotations"`
+	StripLabelPrefix            StringList    `yaml:"stripLabelPrefix"`
+	HideLabels                  StringList    `yaml:"hideLabels"`
+	LabelRender                 string        `yaml:"labelRender"`
+	LabelNewlines               string        `yaml:"labelNewlines"`
+	FiringDuration              bool          `yaml:"firingDuration"`
+	RuleLink                    bool          `yaml:"ruleLink"`
+	FingerprintLink             bool          `yaml:"fingerprintLink"`
+	ValuePrecision              int           `yaml:"valuePrecision"`
+	ValueUnitStyle              string        `yaml:"valueUnitStyle"`
+	DateFormat                  string        `yaml:"dateFormat"`
+	Timezone                    string        `yaml:"timezone"`
+	LayoutLabel                 string        `yaml:"layoutLabel"`
+	Layouts                     StringMap     `yaml:"layouts"`
+	ChannelLayouts              StringMap     `yaml:"channelLayouts"`
+	StatusEmoji                 StringMap     `yaml:"statusEmoji"`
+	SeverityColors              StringMap     `yaml:"severityColors"`
+	SeverityEmoji               StringMap     `yaml:"severityEmoji"`
+	SeverityMapFile             string        `yaml:"severityMapFile"`
+	SeverityMap                 SeverityMap   `yaml:"severityMap"`
+	ColorBySeverity             bool          `yaml:"colorBySeverity"`
+	SeverityOrder               StringList    `yaml:"severityOrder"`
+	DigestLine                  bool          `yaml:"digestLine"`
+	SummaryThreshold            int           `yaml:"summaryThreshold"`
+	GroupBy                     string        `yaml:"groupBy"`
+	Compact                     bool          `yaml:"compact"`
+	ShowCommonLabels            bool          `yaml:"showCommonLabels"`
+	ExternalURLText             string        `yaml:"externalUrlText"`
+	FooterLinks                 FooterLinks   `yaml:"footerLinks"`
+	SnoozeAlertmanagerUrl       string        `yaml:"snoozeAlertmanagerUrl"`
+	SnoozeApiToken              string        `yaml:"snoozeApiToken"`
+	SnoozeDuration              time.Duration `yaml:"snoozeDuration"`
+	SlackSigningSecret          string        `yaml:"slackSigningSecret"`
+	DedupWindow                 time.Duration `yaml:"dedupWindow"`
+	ResolvedDedupWindow         time.Duration `yaml:"resolvedDedupWindow"`
+	MaxConcurrent               int           `yaml:"maxConcurrent"`
+	MaxConcurrentWait           time.Duration `yaml:"maxConcurrentWait"`
+	WorkspaceBackoff            bool          `yaml:"workspaceBackoff"`
+	RetryBudget                 time.Duration `yaml:"retryBudget"`
+	SlackRateLimit              float64       `yaml:"slackRateLimit"`
+	PostConcurrency             int           `yaml:"postConcurrency"`
+	ReadinessInterval           time.Duration `yaml:"readinessInterval"`
+	ReadTimeout                 time.Duration `yaml:"readTimeout"`
+	WriteTimeout                time.Duration `yaml:"writeTimeout"`
+	IdleTimeout                 time.Duration `yaml:"idleTimeout"`
+	ShutdownDelay               time.Duration `yaml:"shutdownDelay"`
+	MaxBodyBytes                int64         `yaml:"maxBodyBytes"`
+	SlackTimeout                time.Duration `yaml:"slackTimeout"`
+	UnfurlLinks                 bool          `yaml:"unfurlLinks"`
+	DebugHTTP                   bool          `yaml:"debugHttp"`
+	NotifyTest                  bool          `yaml:"notifyTest"`
+	NotifyFiltered              bool          `yaml:"notifyFiltered"`
+	DryRun                      bool          `yaml:"dryRun"`
+	HmacSecret                  string        `yaml:"hmacSecret"`
+	HmacHeader                  string        `yaml:"hmacHeader"`
+
+	// location is the loaded Timezone, validateConfig resolves it once
+	location *time.Location
+}
+
+// StringList is a flag.Value accepting comma separated values.
+type StringList []string
+
+func (l *StringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *StringList) Set(value string) error {
+	var values StringList
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	*l = values
+	return nil
+}
+
+// StringMap is a flag.Value accepting comma separated key=value pairs.
+type StringMap map[string]string
+
+func (m *StringMap) String() string {
+	var pairs []string
+	for k, v := range *m {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (m *StringMap) Set(value string) error {
+	values := StringMap{}
+	for _, pair := range strings.Split(value, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("expected key=value, got %q", pair)
+		}
+		values[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	*m = values
+	return nil
+}
+
+type LabelMatcher struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+}
+
+// LabelMatchers is a flag.Value accepting comma separated name=value pairs,
+// repeated flags accumulate.
+type LabelMatchers []LabelMatcher
+
+func (m *LabelMatchers) String() string {
+	var pairs []string
+	for _, matcher := range *m {
+		pairs = append(pairs, matcher.Name+"="+matcher.Value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (m *LabelMatchers) Set(value string) error {
+	matchers := *m
+	for _, pair := range strings.Split(value, ",") {
+		name, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("expected name=value, got %q", pair)
+		}
+		matchers = append(matchers, LabelMatcher{Name: strings.TrimSpace(name), Value: strings.TrimSpace(v)})
+	}
+	*m = matchers
+	return nil
+}
+
+func (m *LabelMatchers) Reset() {
+	*m = nil
+}
+
+type FooterLink struct {
+	Text string `yaml:"text"`
+	URL  string `yaml:"url"`
+}
+
+// FooterLinks is a flag.Value accepting comma separated text=url pairs.
+type FooterLinks []FooterLink
+
+func (l *FooterLinks) String() string {
+	var pairs []string
+	for _, link := range *l {
+		pairs = append(pairs, link.Text+"="+link.URL)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (l *FooterLinks) Set(value string) error {
+	var links FooterLinks
+	for _, pair := range strings.Split(value, ",") {
+		text, link, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("expected text=url, got %q", pair)
+		}
+		links = append(links, FooterLink{Text: strings.TrimSpace(text), URL: strings.TrimSpace(link)})
+	}
+	*l = links
+	return nil
+}
+
+// SeverityStyle is the emoji, attachment color and mention used for firing
+// alerts of a severity and the channel their notifications are routed to.
+type SeverityStyle struct {
+	Emoji   string `yaml:"emoji" json:"emoji"`
+	Color   string `yaml:"color" json:"color"`
+	Channel string `yaml:"channel" json:"channel"`
+	Mention string `yaml:"mention" json:"mention"`
+}
+
+// SeverityMap maps values of the severity label to their style.
+type SeverityMap map[string]SeverityStyle
+
+var hexColor = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
+// loadSeverityMap reads a severity map from a YAML or JSON file.
+func loadSeverityMap(path string) (SeverityMap, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m SeverityMap
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m, m.validate()
+}
+
+func (m SeverityMap) validate() error {
+	for severity, style := range m {
+		if style == (SeverityStyle{}) {
+			return fmt.Errorf("severity '%s' has neither emoji, color, channel nor mention", severity)
+		}
+		if style.Color != "" && !hexColor.MatchString(style.Color) {
+			return fmt.Errorf("severity '%s' has invalid color '%s', expected #RRGGBB", severity, style.Color)
+		}
+	}
+	return nil
+}
+
+// RouteMatcher matches the value of a label exactly, or as an anchored
+// regular expression when Regex is set.
+type RouteMatcher struct {
+	Name  string `yaml:"name" json:"name"`
+	Value string `yaml:"value" json:"value"`
+	Regex bool   `yaml:"regex" json:"regex"`
+	re    *regexp.Regexp
+}
+
+// Route sends notifications whose common labels match all matchers to the
+// channel, a route without matchers matches every notification.
+type Route struct {
+	Matchers []RouteMatcher `yaml:"matchers" json:"matchers"`
+	Channel  string         `yaml:"channel" json:"channel"`
+}
+
+// Routes are evaluated in order and the first matching route wins.
+type Routes []Route
+
+// loadRoutes reads routes from a YAML or JSON file.
+func loadRoutes(path string) (Routes, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var routes Routes
+	if err := yaml.Unmarshal(data, &routes); err != nil {
+		return nil, err
+	}
+	return routes, routes.compile()
+}
+
+// compile validates the routes and compiles their regex matchers in place.
+func (r Routes) compile() error {
+	for i, route := range r {
+		if route.Channel == "" {
+			return fmt.Errorf("route %d has no channel", i+1)
+		}
+		for j, matcher := range route.Matchers {
+			if matcher.Name == "" {
+				return fmt.Errorf("route %d has a matcher without label name", i+1)
+			}
+			if !matcher.Regex {
+				continue
+			}
+			re, err := regexp.Compile("^(?:" + matcher.Value + ")$")
+			if err != nil {
+				return fmt.Errorf("route %d has invalid regex for '%s': %w", i+1, matcher.Name, err)
+			}
+			r[i].Matchers[j].re = re
+		}
+	}
+	return nil
+}
+
+// loadConfig reads the YAML file into cfg on top of the flag defaults and then
+// re-applies every flag that was set explicitly, so flags win over the file.
+func loadConfig(fs *flag.FlagSet, path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	explicit := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return err
+	}
+	for name, value := range explicit {
+		// accumulating values would otherwise append the flag to the file values
+		if r, ok := fs.Lookup(name).Value.(interface{ Reset() }); ok {
+			r.Reset()
+		}
+		if err := fs.Set(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// envPrefix namespaces the environment variables of the flags, the prefixed
+// variable wins when both are set.
+const envPrefix = "GSA_"
+
+// applyEnvFallback fills every flag that was not set on the command line from
+// the matching environment variable, e.g. -webhook-url from GSA_WEBHOOK_URL or
+// WEBHOOK_URL and -grafanaUrl from GSA_GRAFANA_URL or GRAFANA_URL. Invalid
+// values are returned as errors since a reload must not exit the process.
+func applyEnvFallback(fs *flag.FlagSet) error {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] {
+			return
+		}
+		for _, name := range []string{envPrefix + envName(f.Name), envName(f.Name)} {
+			if value, ok := os.LookupEnv(name); ok {
+				if err := fs.Set(f.Name, value); err != nil {
+					errs = append(errs, fmt.Errorf("invalid value %q for %s: %w", value, name, err))
+				}
+				return
+			}
+		}
+	})
+	return errors.Join(errs...)
+}
+
+func envName(flagName string) string {
+	var b strings.Builder
+	for i, r := range flagName {
+		switch {
+		case r == '-':
+			b.WriteRune('_')
+		case unicode.IsUpper(r) && i > 0:
+			b.WriteRune('_')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loadTestConfig parses and validates the config like main does.
+func loadTestConfig(t *testing.T, args ...string) (Config, error) {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	config, _, err := parseConfig(fs, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config, validateConfig(&config)
+}
+
+func writeTestFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSeverityMapFile(t *testing.T) {
+	path := writeTestFile(t, "severities.yaml", `
+critical:
+  emoji: ":fire:"
+  color: "#FF0000"
+  mention: "@here"
+info:
+  emoji: ":information_source:"
+`)
+	config, err := loadTestConfig(t, "-dry-run", "-severity-map", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newHandler(config)
+
+	critical := testAlert("firing", "a")
+	critical.Labels["severity"] = "critical"
+	info := testAlert("firing", "b")
+	info.Labels["severity"] = "info"
+	messages := h.buildMessages(GrafanaMsg{Alerts: []Alert{critical, info}}, "alerts")
+	if len(messages) != 1 {
+		t.Fatalf("messages = %d, want 1", len(messages))
+	}
+	blocks := blocksJSON(t, messages[0])
+	for _, want := range []string{":fire: a", ":information_source: b", `\u003c!here\u003e`} {
+		if !strings.Contains(blocks, want) {
+			t.Errorf("blocks miss %q: %s", want, blocks)
+		}
+	}
+	if !strings.HasPrefix(messages[0].Text, "<!here> ") {
+		t.Errorf("preview text = %q, want the mention first", messages[0].Text)
+	}
+	if color := h.severityColor(critical); color != "#FF0000" {
+		t.Errorf("critical color = %s, want #FF0000", color)
+	}
+}
+
+func TestSeverityMapFileInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{name: "invalid color", content: "critical:\n  color: red\n", want: "invalid color 'red'"},
+		{name: "empty style", content: "critical: {}\n", want: "has neither emoji"},
+		{name: "not a map", content: "- critical\n", want: "invalid severity-map"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, "-dry-run", "-severity-map", writeTestFile(t, "severities.yaml", tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerTimeouts(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  []string
+		read  time.Duration
+		write time.Duration
+		idle  time.Duration
+	}{
+		{name: "defaults", read: 5 * time.Second, write: 30 * time.Second, idle: 120 * time.Second},
+		{
+			name:  "configured",
+			args:  []string{"-read-timeout", "2s", "-write-timeout", "1m", "-idle-timeout", "10s"},
+			read:  2 * time.Second,
+			write: time.Minute,
+			idle:  10 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := loadTestConfig(t, append([]string{"-dry-run"}, tt.args...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			server := newServer(config, http.NewServeMux())
+			if server.ReadTimeout != tt.read || server.WriteTimeout != tt.write || server.IdleTimeout != tt.idle {
+				t.Errorf("timeouts = %s/%s/%s, want %s/%s/%s", server.ReadTimeout, server.WriteTimeout, server.IdleTimeout, tt.read, tt.write, tt.idle)
+			}
+		})
+	}
+	if _, err := loadTestConfig(t, "-dry-run", "-shutdown-delay", "-1s"); err == nil {
+		t.Error("want an error for a negative shutdown-delay")
+	}
+	if _, err := loadTestConfig(t, "-dry-run", "-write-timeout", "0s"); err == nil {
+		t.Error("want an error for a zero write-timeout")
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	tests := map[string]string{
+		"webhook-url":          "WEBHOOK_URL",
+		"grafanaUrl":           "GRAFANA_URL",
+		"grafanaAlertSource":   "GRAFANA_ALERT_SOURCE",
+		"dry-run":              "DRY_RUN",
+		"grafanaSilenceButton": "GRAFANA_SILENCE_BUTTON",
+	}
+	for flagName, want := range tests {
+		if got := envName(flagName); got != want {
+			t.Errorf("envName(%q) = %q, want %q", flagName, got, want)
+		}
+	}
+}
+
+func TestEnvFallback(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want string
+	}{
+		{name: "unprefixed", env: map[string]string{"GRAFANA_URL": "http://plain"}, want: "http://plain"},
+		{name: "prefixed", env: map[string]string{"GSA_GRAFANA_URL": "http://prefixed"}, want: "http://prefixed"},
+		{
+			name: "prefixed wins over unprefixed",
+			env:  map[string]string{"GSA_GRAFANA_URL": "http://prefixed", "GRAFANA_URL": "http://plain"},
+			want: "http://prefixed",
+		},
+		{
+			name: "flag wins over env",
+			env:  map[string]string{"GSA_GRAFANA_URL": "http://prefixed", "GRAFANA_URL": "http://plain"},
+			args: []string{"-grafanaUrl", "http://flag"},
+			want: "http://flag",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			config, err := loadTestConfig(t, append([]string{"-dry-run"}, tt.args...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.GrafanaUrl != tt.want {
+				t.Errorf("grafana url = %q, want %q", config.GrafanaUrl, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnvFallbackInvalidValue(t *testing.T) {
+	t.Setenv("GSA_POST_CONCURRENCY", "many")
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	_, _, err := parseConfig(fs, []string{"-dry-run"})
+	if err == nil || !strings.Contains(err.Error(), "GSA_POST_CONCURRENCY") {
+		t.Errorf("err = %v, want the invalid env value reported", err)
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	path := writeTestFile(t, "config.yaml", `
+username: file
+iconEmoji: ":file:"
+defaultChannel: "#file"
+`)
+	t.Setenv("GSA_ICON_EMOJI", ":env:")
+	t.Setenv("GSA_DEFAULT_CHANNEL", "#env")
+	config, err := loadTestConfig(t, "-dry-run", "-config", path, "-default-channel", "#flag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Username != "file" || config.IconEmoji != ":env:" || config.DefaultChannel != "#flag" {
+		t.Errorf("username, icon and channel = %q, %q, %q, want file < env < flag", config.Username, config.IconEmoji, config.DefaultChannel)
+	}
+}
+
+func TestLabelMatchersFlagReplacesFile(t *testing.T) {
+	path := writeTestFile(t, "config.yaml", `
+includeLabels:
+  - name: team
+    value: db
+excludeLabels:
+  - name: env
+    value: staging
+`)
+	config, err := loadTestConfig(t, "-dry-run", "-config", path, "-include-labels", "team=web", "-include-labels", "region=eu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := LabelMatchers{{Name: "team", Value: "web"}, {Name: "region", Value: "eu"}}
+	if !slices.Equal(config.IncludeLabels, want) {
+		t.Errorf("include labels = %v, want the flags replacing the file values", config.IncludeLabels)
+	}
+	if want := (LabelMatchers{{Name: "env", Value: "staging"}}); !slices.Equal(config.ExcludeLabels, want) {
+		t.Errorf("exclude labels = %v, want the file values", config.ExcludeLabels)
+	}
+}
+
+func TestTimezone(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name     string
+		timezone string
+		want     string
+	}{
+		{name: "loaded", timezone: "Europe/Berlin", want: "Started: 2024-01-02 04:04"},
+		{name: "invalid falls back to utc", timezone: "Mars/Olympus", want: "Started: 2024-01-02 03:04"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := loadTestConfig(t, "-dry-run", "-date-format", "2006-01-02 15:04", "-timezone", tt.timezone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := newHandler(config).formatTime("Started", at); got != tt.want {
+				t.Errorf("formatTime = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigFlagDefaults(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	defaults, _, err := parseConfig(fs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// tests post one message at a time
+	want := testConfig()
+	want.PostConcurrency = defaults.PostConcurrency
+	got, expected := reflect.ValueOf(defaults), reflect.ValueOf(want)
+	for i := 0; i < got.NumField(); i++ {
+		if field := got.Type().Field(i); field.IsExported() && !reflect.DeepEqual(got.Field(i).Interface(), expected.Field(i).Interface()) {
+			t.Errorf("testConfig %s = %v, want the flag default %v", field.Name, expected.Field(i), got.Field(i))
+		}
+	}
+}
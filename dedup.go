@@ -0,0 +1,100 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// seenCache remembers keys for a time window, expired keys are evicted lazily
+// whenever the cache is consulted.
+type seenCache struct {
+	mu     sync.Mutex
+	window time.Duration
+	seen   map[string]time.Time
+}
+
+func newSeenCache(window time.Duration) *seenCache {
+	return &seenCache{window: window, seen: map[string]time.Time{}}
+}
+
+func (c *seenCache) contains(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evict(time.Now())
+	_, ok := c.seen[key]
+	return ok
+}
+
+func (c *seenCache) add(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for _, key := range keys {
+		c.seen[key] = now
+	}
+}
+
+func (c *seenCache) remove(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		delete(c.seen, key)
+	}
+}
+
+func (c *seenCache) evict(now time.Time) {
+	for key, seenAt := range c.seen {
+		if now.Sub(seenAt) >= c.window {
+			delete(c.seen, key)
+		}
+	}
+}
+
+func alertKey(alert Alert) string {
+	if alert.Fingerprint != "" {
+		return alert.Fingerprint
+	}
+	return hash(alert.Labels)
+}
+
+// dropRepeatedResolutions removes resolved alerts that were already posted
+// within the resolved dedup window and returns the keys of the kept ones.
+func (h *Handler) dropRepeatedResolutions(alerts []Alert) ([]Alert, []string) {
+	var kept []Alert
+	var resolvedKeys []string
+	for _, alert := range alerts {
+		if alert.Status != "resolved" {
+			kept = append(kept, alert)
+			continue
+		}
+		key := alertKey(alert)
+		if h.resolved.contains(key) {
+			continue
+		}
+		kept = append(kept, alert)
+		resolvedKeys = append(resolvedKeys, key)
+	}
+	return kept, resolvedKeys
+}
+
+// dropRepeatedFirings removes firing alerts that were already posted within the
+// dedup window and returns the keys of the kept ones. A resolution forgets the
+// alert, so it is posted again when it fires next time.
+func (h *Handler) dropRepeatedFirings(alerts []Alert) ([]Alert, []string) {
+	var kept []Alert
+	var firingKeys []string
+	for _, alert := range alerts {
+		key := alertKey(alert)
+		if alert.Status == "resolved" {
+			h.firing.remove(key)
+			kept = append(kept, alert)
+			continue
+		}
+		if h.firing.contains(key) {
+			continue
+		}
+		kept = append(kept, alert)
+		firingKeys = append(firingKeys, key)
+	}
+	return kept, firingKeys
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRepeatedResolutionIsSuppressed(t *testing.T) {
+	config := testConfig()
+	config.ResolvedDedupWindow = time.Hour
+	h, webhook := webhookHandler(t, config)
+	resolved := GrafanaMsg{Alerts: []Alert{resolvedAlert(testAlert("firing", "a"))}}
+	resolved.Alerts[0].Fingerprint = "fp-a"
+
+	for i := 0; i < 2; i++ {
+		if w := notify(t, h, "/", resolved); w.Code != http.StatusOK {
+			t.Fatalf("notification %d: status = %d, want 200", i+1, w.Code)
+		}
+	}
+	if posted := webhook.posted(); len(posted) != 1 {
+		t.Errorf("posted %d messages, want the second resolution to be suppressed", len(posted))
+	}
+
+	// another alert is posted although it resolves within the window as well
+	other := GrafanaMsg{Alerts: []Alert{resolvedAlert(testAlert("firing", "b"))}}
+	notify(t, h, "/", other)
+	if posted := webhook.posted(); len(posted) != 2 {
+		t.Errorf("posted %d messages, want the resolution of another alert to be posted", len(posted))
+	}
+}
+
+func TestSeenCacheExpires(t *testing.T) {
+	cache := newSeenCache(20 * time.Millisecond)
+	cache.add("a")
+	if !cache.contains("a") {
+		t.Fatal("key is not seen within the window")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if cache.contains("a") {
+		t.Error("key is still seen after the window")
+	}
+}
+
+func TestRepeatedFiringIsSuppressed(t *testing.T) {
+	config := testConfig()
+	config.DedupWindow = time.Hour
+	h, webhook := webhookHandler(t, config)
+	firing := testAlert("firing", "a")
+	firing.Fingerprint = "fp-a"
+
+	for i, alert := range []Alert{firing, firing, resolvedAlert(firing), firing} {
+		if w := notify(t, h, "/", GrafanaMsg{Alerts: []Alert{alert}}); w.Code != http.StatusOK {
+			t.Fatalf("notification %d: status = %d, want 200", i+1, w.Code)
+		}
+	}
+	want := []string{"Fired: [a] ", "Resolved: [a] ", "Fired: [a] "}
+	if got := previewTexts(webhook.posted()); !slices.Equal(got, want) {
+		t.Errorf("posted %q, want the repeat suppressed and the alert posted again after it resolved", got)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import "log/slog"
+
+func (m LabelMatcher) matches(labels map[string]string) bool {
+	value, ok := labels[m.Name]
+	return ok && value == m.Value
+}
+
+// filterAlerts keeps alerts matching all include matchers, when any are
+// configured, and none of the exclude or drop matchers.
+func (h *Handler) filterAlerts(alerts []Alert) []Alert {
+	var kept []Alert
+	for _, alert := range alerts {
+		if matcher, ok := h.missedInclude(alert); ok {
+			slog.Debug("dropped alert not matching include label", "labels", alert.Labels, "matcher", matcher.Name+"="+matcher.Value)
+			continue
+		}
+		if matcher, ok := h.matchedExclude(alert); ok {
+			slog.Debug("dropped alert matching exclude label", "labels", alert.Labels, "matcher", matcher.Name+"="+matcher.Value)
+			continue
+		}
+		kept = append(kept, alert)
+	}
+	return kept
+}
+
+func (h *Handler) missedInclude(alert Alert) (LabelMatcher, bool) {
+	for _, matcher := range h.config.IncludeLabels {
+		if !matcher.matches(alert.Labels) {
+			return matcher, true
+		}
+	}
+	return LabelMatcher{}, false
+}
+
+func (h *Handler) matchedExclude(alert Alert) (LabelMatcher, bool) {
+	for _, matchers := range []LabelMatchers{h.config.ExcludeLabels, h.config.DropLabels} {
+		for _, matcher := range matchers {
+			if matcher.matches(alert.Labels) {
+				return matcher, true
+			}
+		}
+	}
+	return LabelMatcher{}, false
+}
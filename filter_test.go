@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAllAlertsFiltered(t *testing.T) {
+	tests := []struct {
+		name           string
+		notifyFiltered bool
+		want           []string
+	}{
+		{name: "silently", notifyFiltered: false},
+		{name: "notice", notifyFiltered: true, want: []string{":see_no_evil: All 2 alerts of a notification from team-db were filtered out"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			config.ExcludeLabels = LabelMatchers{{Name: "env", Value: "staging"}}
+			config.NotifyFiltered = tt.notifyFiltered
+			h, webhook := webhookHandler(t, config)
+			a, b := testAlert("firing", "a"), testAlert("firing", "b")
+			a.Labels["env"] = "staging"
+			b.Labels["env"] = "staging"
+			if w := notify(t, h, "/", GrafanaMsg{Receiver: "team-db", Alerts: []Alert{a, b}}); w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", w.Code)
+			}
+			got := previewTexts(webhook.posted())
+			if len(got) != len(tt.want) || (len(got) == 1 && got[0] != tt.want[0]) {
+				t.Errorf("posted %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterAlerts(t *testing.T) {
+	labelled := func(name string, labels map[string]string) Alert {
+		alert := testAlert("firing", name)
+		for label, value := range labels {
+			alert.Labels[label] = value
+		}
+		return alert
+	}
+	alerts := []Alert{
+		labelled("a", map[string]string{"team": "db", "env": "prod"}),
+		labelled("b", map[string]string{"team": "db", "env": "staging"}),
+		labelled("c", map[string]string{"team": "web", "env": "prod"}),
+		labelled("d", map[string]string{"team": "db", "env": "prod", "slack": "false"}),
+	}
+	config := testConfig()
+	config.IncludeLabels = LabelMatchers{{Name: "team", Value: "db"}}
+	config.ExcludeLabels = LabelMatchers{{Name: "env", Value: "staging"}}
+	config.DropLabels = LabelMatchers{{Name: "slack", Value: "false"}}
+	h := newHandler(config)
+	kept := h.filterAlerts(alerts)
+	if len(kept) != 1 || kept[0].Labels["alertname"] != "a" {
+		t.Errorf("kept %v, want only a", kept)
+	}
+}
+
+func TestSuppressedAlertIsNotRendered(t *testing.T) {
+	config := testConfig()
+	config.DropLabels = LabelMatchers{{Name: "slack", Value: "false"}}
+	h, webhook := webhookHandler(t, config)
+	suppressed := testAlert("firing", "quiet")
+	suppressed.Labels["slack"] = "false"
+	if w := notify(t, h, "/", GrafanaMsg{Alerts: []Alert{testAlert("firing", "loud"), suppressed}}); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+
+	posted := webhook.posted()
+	if len(posted) != 1 {
+		t.Fatalf("posted %d messages, want 1", len(posted))
+	}
+	if posted[0].Text != "Fired: [loud] " {
+		t.Errorf("preview text = %q, want only the loud alert", posted[0].Text)
+	}
+	if blocks := blocksJSON(t, posted[0]); strings.Contains(blocks, "quiet") {
+		t.Errorf("blocks render the suppressed alert: %s", blocks)
+	}
+}
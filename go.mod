@@ -1,14 +1,16 @@
 module grafana-slack-alerter
 
-go 1.18
+go 1.21
 
 require (
 	github.com/ory/graceful v0.1.3
-	github.com/slack-go/slack v0.11.2
-	golang.org/x/exp v0.0.0-20220722155223-a9213eeb770e
+	github.com/slack-go/slack v0.12.5
+	golang.org/x/time v0.5.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
+	github.com/google/go-cmp v0.5.8 // indirect
 	github.com/gorilla/websocket v1.5.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 )
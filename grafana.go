@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// amAlert is an alert as returned by the alertmanager v2 api of grafana.
+type amAlert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+// fetchTruncatedAlerts completes a notification truncated by grafana with the
+// active alerts of its group fetched from the grafana alertmanager api.
+func (h *Handler) fetchTruncatedAlerts(ctx context.Context, msg *GrafanaMsg) error {
+	grafanaUrl := h.config.GrafanaUrl
+	if grafanaUrl == "" {
+		grafanaUrl = msg.ExternalURL
+	}
+	query := url.Values{}
+	for _, pair := range sortedLabels(msg.GroupLabels) {
+		query.Add("filter", fmt.Sprintf("%s=%s", pair.Name, strconv.Quote(pair.Value)))
+	}
+	if msg.Receiver != "" {
+		query.Set("receiver", "^"+regexp.QuoteMeta(msg.Receiver)+"$")
+	}
+	query.Set("silenced", "false")
+	query.Set("inhibited", "false")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(grafanaUrl, "/")+"/api/alertmanager/grafana/api/v2/alerts?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+h.config.GrafanaApiToken)
+	if msg.OrgID != 0 {
+		req.Header.Set("X-Grafana-Org-Id", strconv.FormatInt(msg.OrgID, 10))
+	}
+	res, err := apiClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("grafana responded with %s", res.Status)
+	}
+	var fetched []amAlert
+	if err := json.NewDecoder(res.Body).Decode(&fetched); err != nil {
+		return err
+	}
+
+	known := map[string]bool{}
+	for _, alert := range msg.Alerts {
+		known[alertKey(alert)] = true
+	}
+	for _, a := range fetched {
+		alert := Alert{
+			Status:       "firing",
+			Labels:       a.Labels,
+			Annotations:  a.Annotations,
+			StartsAt:     a.StartsAt,
+			GeneratorURL: a.GeneratorURL,
+			Fingerprint:  a.Fingerprint,
+		}
+		if known[alertKey(alert)] {
+			continue
+		}
+		known[alertKey(alert)] = true
+		msg.Alerts = append(msg.Alerts, alert)
+		msg.TruncatedAlerts = max(msg.TruncatedAlerts-1, 0)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestFetchTruncatedAlerts(t *testing.T) {
+	var req *http.Request
+	grafana := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req = r
+		_ = json.NewEncoder(w).Encode([]amAlert{
+			{Labels: map[string]string{"alertname": "a"}, Fingerprint: "fp-a"},
+			{Labels: map[string]string{"alertname": "b"}, Annotations: map[string]string{"summary": "b"}, Fingerprint: "fp-b"},
+			{Labels: map[string]string{"alertname": "c"}, Fingerprint: "fp-c"},
+		})
+	}))
+	defer grafana.Close()
+
+	config := testConfig()
+	config.GrafanaApiToken = "token"
+	h := newHandler(config)
+	known := testAlert("firing", "a")
+	known.Fingerprint = "fp-a"
+	msg := GrafanaMsg{
+		Receiver:        "team-db",
+		OrgID:           2,
+		ExternalURL:     grafana.URL + "/",
+		GroupLabels:     map[string]string{"alertname": "a", "team": "db"},
+		TruncatedAlerts: 2,
+		Alerts:          []Alert{known},
+	}
+	if err := h.fetchTruncatedAlerts(context.Background(), &msg); err != nil {
+		t.Fatal(err)
+	}
+
+	if req.URL.Path != "/api/alertmanager/grafana/api/v2/alerts" {
+		t.Errorf("path = %s, want the grafana alertmanager api", req.URL.Path)
+	}
+	query := req.URL.Query()
+	if filters := query["filter"]; !slices.Equal(filters, []string{`alertname="a"`, `team="db"`}) {
+		t.Errorf("filters = %q, want the group labels", filters)
+	}
+	if query.Get("receiver") != "^team-db$" || query.Get("silenced") != "false" || query.Get("inhibited") != "false" {
+		t.Errorf("query = %s, want the active alerts of the receiver", req.URL.RawQuery)
+	}
+	if req.Header.Get("Authorization") != "Bearer token" || req.Header.Get("X-Grafana-Org-Id") != "2" {
+		t.Errorf("headers = %v, want the api token and org id", req.Header)
+	}
+
+	var fingerprints []string
+	for _, alert := range msg.Alerts {
+		fingerprints = append(fingerprints, alert.Fingerprint)
+		if alert.Status != "firing" {
+			t.Errorf("alert %s is %s, want firing", alert.Fingerprint, alert.Status)
+		}
+	}
+	if !slices.Equal(fingerprints, []string{"fp-a", "fp-b", "fp-c"}) {
+		t.Errorf("alerts = %q, want the fetched alerts merged without duplicates", fingerprints)
+	}
+	if msg.TruncatedAlerts != 0 {
+		t.Errorf("truncated alerts = %d, want 0", msg.TruncatedAlerts)
+	}
+}
+
+func TestFetchTruncatedAlertsOnNotification(t *testing.T) {
+	grafana := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode([]amAlert{{Labels: map[string]string{"alertname": "b"}, Fingerprint: "fp-b"}})
+	}))
+	defer grafana.Close()
+
+	config := testConfig()
+	config.GrafanaApiToken = "token"
+	config.GrafanaUrl = grafana.URL
+	h, webhook := webhookHandler(t, config)
+	known := testAlert("firing", "a")
+	known.Fingerprint = "fp-a"
+	if w := notify(t, h, "/", GrafanaMsg{TruncatedAlerts: 1, Alerts: []Alert{known}}); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if got := previewTexts(webhook.posted()); !slices.Equal(got, []string{"Fired: [a] [b] "}) {
+		t.Errorf("preview texts = %q, want the fetched alert rendered", got)
+	}
+}
+
+func TestFetchTruncatedAlertsFailure(t *testing.T) {
+	grafana := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer grafana.Close()
+
+	config := testConfig()
+	config.GrafanaApiToken = "token"
+	config.GrafanaUrl = grafana.URL
+	h := newHandler(config)
+	msg := GrafanaMsg{TruncatedAlerts: 1, Alerts: []Alert{testAlert("firing", "a")}}
+	if err := h.fetchTruncatedAlerts(context.Background(), &msg); err == nil {
+		t.Error("want an error for a failing grafana api")
+	}
+	if len(msg.Alerts) != 1 || msg.TruncatedAlerts != 1 {
+		t.Errorf("notification changed to %d alerts and %d truncated", len(msg.Alerts), msg.TruncatedAlerts)
+	}
+}
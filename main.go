@@ -1,243 +1,1459 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/ory/graceful"
 	"github.com/slack-go/slack"
+	"golang.org/x/time/rate"
 	"hash/fnv"
 	"io"
 	"log"
+	"log/slog"
 	"math"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"os"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	_ "time/tzdata"
 )
 
-var webhookUrl string
-var username string
-var grafanaAlertSource bool
-var grafanaUrl string
-var disableGrafanaSilenceButton bool
-
 func main() {
-	flag.StringVar(&webhookUrl, "webhook-url", "", "Slack webhook url")
-	flag.StringVar(&username, "username", "Grafana", "Slack username")
-	flag.BoolVar(&grafanaAlertSource, "grafanaAlertSource", true, "Set to false to use alerter with external alert manager")
-	flag.StringVar(&grafanaUrl, "grafanaUrl", "", "URL to grafana (applicable only when grafanaAlertSource=false)")
-	flag.BoolVar(&disableGrafanaSilenceButton, "grafanaSilenceButton", true, "Set to false to enable silence button in the alert message")
-	flag.Parse()
-
-	http.HandleFunc("/slack", handleWebhookRequest)
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+	config, configFile, err := parseConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatalln(err)
+	}
+	logger, err := newLogger(config.LogFormat, config.LogLevel)
+	if err != nil {
+		log.Fatalln("invalid logging config:", err)
+	}
+	slog.SetDefault(logger)
+	if err := validateConfig(&config); err != nil {
+		log.Fatalln(err)
+	}
+
+	handler := newReloader(newHandler(config), configFile, os.Args[1:])
+
+	server := newServer(config, newMux(config, handler))
+
+	http.DefaultTransport = LoggingRoundTripper{Proxied: http.DefaultTransport, Debug: config.DebugHTTP}
+
+	listenAndServe := server.ListenAndServe
+	if config.TLSCert != "" {
+		listenAndServe = func() error {
+			return server.ListenAndServeTLS(config.TLSCert, config.TLSKey)
+		}
+	}
+
+	shutdown := func(ctx context.Context) error {
+		return drainAndShutdown(ctx, server, handler, config.ShutdownDelay)
+	}
+	// the drain delay must not eat into the time left to finish requests
+	graceful.DefaultShutdownTimeout += config.ShutdownDelay
+
+	slog.Info("starting the server", "address", config.ListenAddress, "tls", config.TLSCert != "")
+	handler.current().ready.Store(true)
+	go handler.watch(config.ConfigWatchInterval)
+	if err := graceful.Graceful(listenAndServe, shutdown); err != nil {
+		slog.Error("failed to gracefully shutdown", "err", err)
+		os.Exit(1)
+	}
+	slog.Info("server stopped")
+}
+
+// newMux routes the endpoints under the path prefix of the config.
+func newMux(config Config, handler *reloader) *http.ServeMux {
+	mux := http.NewServeMux()
+	prefix := normalizePathPrefix(config.PathPrefix)
+	mux.HandleFunc(prefix+"/slack", handler.handle((*Handler).handleWebhookRequest))
+	if config.SnoozeAlertmanagerUrl != "" {
+		mux.HandleFunc(prefix+"/slack/interactions", handler.handle((*Handler).handleInteraction))
+	}
+	healthz := func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
-	})
+	}
+	mux.HandleFunc(prefix+"/healthz", healthz)
+	mux.HandleFunc(prefix+"/health", healthz)
+	mux.HandleFunc(prefix+"/readyz", handler.handle((*Handler).handleReady))
+	mux.HandleFunc(prefix+"/ready", handler.handle((*Handler).handleReady))
+	return mux
+}
 
-	server := graceful.WithDefaults(&http.Server{
-		Addr:    ":8080",
-		Handler: http.DefaultServeMux,
+// newServer sets up the http server with the listen address and timeouts of
+// the config, graceful only fills in the settings left out.
+func newServer(config Config, handler http.Handler) *http.Server {
+	return graceful.WithDefaults(&http.Server{
+		Addr:         config.ListenAddress,
+		Handler:      handler,
+		ReadTimeout:  config.ReadTimeout,
+		WriteTimeout: config.WriteTimeout,
+		IdleTimeout:  config.IdleTimeout,
 	})
+}
 
-	http.DefaultTransport = LoggingRoundTripper{http.DefaultTransport}
+// drainAndShutdown reports not ready and keeps serving for the delay, so load
+// balancers polling /readyz stop routing requests before the listener closes.
+func drainAndShutdown(ctx context.Context, server *http.Server, handler *reloader, delay time.Duration) error {
+	handler.current().ready.Store(false)
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+	}
+	return server.Shutdown(ctx)
+}
 
-	log.Println("starting the server")
-	if err := graceful.Graceful(server.ListenAndServe, server.Shutdown); err != nil {
-		log.Fatalln("failed to gracefully shutdown")
+// parseConfig builds the config from args, environment variables and the
+// config file, in this order of precedence, and returns the config file path.
+func parseConfig(fs *flag.FlagSet, args []string) (Config, string, error) {
+	config := Config{ChannelPrecedence: StringList{"query", "label", "route", "severity", "org"}}
+	var configFile string
+	fs.StringVar(&configFile, "config", "", "Path to YAML or JSON config file, flags override values from the file")
+	fs.DurationVar(&config.ConfigWatchInterval, "config-watch-interval", 30*time.Second, "How often the config file is checked for changes to reload it, 0 disables the check, SIGHUP always reloads")
+	fs.StringVar(&config.LogFormat, "log-format", "text", "Log output format: text or json")
+	fs.StringVar(&config.LogLevel, "log-level", "info", "Minimum log level: debug, info, warn or error")
+	fs.StringVar(&config.ListenAddress, "listen-address", ":8080", "Address in host:port form the server listens on")
+	fs.StringVar(&config.PathPrefix, "path-prefix", "", "Path prefix of all endpoints, e.g. /alerter serves /alerter/slack and /alerter/healthz")
+	fs.StringVar(&config.TLSCert, "tls-cert", "", "Path to TLS certificate, the server uses HTTPS when both tls-cert and tls-key are set")
+	fs.StringVar(&config.TLSKey, "tls-key", "", "Path to TLS private key, the server uses HTTPS when both tls-cert and tls-key are set")
+	fs.StringVar(&config.WebhookUrl, "webhook-url", "", "Slack webhook url")
+	fs.Var(&config.WebhookUrls, "webhook-urls", "Comma separated list of name=url pairs of slack webhooks selected with the 'webhook' query param, -webhook-url is used when the param is missing")
+	fs.Var(&config.SinkUrls, "sink-urls", "Comma separated list of urls every message posted to slack is also POSTed to as JSON, e.g. to feed another alerting tool, failures are logged without failing the notification")
+	fs.StringVar(&config.SlackBotToken, "slack-bot-token", "", "Slack bot token, when set messages are posted with the Web API instead of the webhook and updated in place once their alerts resolve")
+	fs.BoolVar(&config.UpdateResolved, "update-resolved", true, "With slack-bot-token, update the message of a firing alert in place when it resolves in a later notification instead of posting a new message")
+	fs.DurationVar(&config.DeleteAfter, "delete-after", 0, "Delete messages whose alerts all have one of delete-severities after this long, disabled when 0 (applicable only with slack-bot-token)")
+	fs.Var(&config.DeleteSeverities, "delete-severities", "Comma separated severity label values whose messages are deleted after delete-after")
+	fs.Var(&config.EphemeralSeverities, "ephemeral-severities", "Comma separated severity label values whose messages are posted as ephemeral messages visible only to ephemeral-user (applicable only with slack-bot-token)")
+	fs.StringVar(&config.EphemeralUser, "ephemeral-user", "", "Slack user id that receives ephemeral messages of ephemeral-severities, the user must be a member of the channel")
+	fs.StringVar(&config.Username, "username", "Grafana", "Slack username")
+	fs.StringVar(&config.IconEmoji, "icon-emoji", "", "Emoji used as slack icon of the messages, e.g. :grafana:")
+	fs.StringVar(&config.IconURL, "icon-url", "", "Image url used as slack icon of the messages, takes precedence over icon-emoji")
+	fs.BoolVar(&config.UsernameFromReceiver, "username-from-receiver", false, "Use the grafana contact point name as slack username, falling back to -username when the notification has no receiver")
+	fs.StringVar(&config.DefaultChannel, "default-channel", "alerts", "Slack channel used when no channel source resolves one")
+	fs.Var(&config.ChannelPrecedence, "channel-precedence", "Comma separated order in which channel sources are consulted: query, label, route, severity, receiver, org")
+	fs.StringVar(&config.RoutesFile, "routes", "", "Path to YAML or JSON file with routes picking the channel from the common labels, the first matching route wins")
+	fs.Var(&config.OrgChannelMap, "org-channel-map", "Comma separated list of orgId=channel pairs used by the 'org' channel source, e.g. 1=team-a-alerts,2=team-b-alerts")
+	fs.StringVar(&config.ChannelLabel, "channel-label", "", "Label shared by all alerts in a notification that holds the slack channel (used by the 'label' channel source)")
+	fs.Var(&config.IncludeLabels, "include-labels", "Comma separated list of key=value label matchers, only alerts matching all of them are sent, can be repeated")
+	fs.Var(&config.ExcludeLabels, "exclude-labels", "Comma separated list of key=value label matchers, alerts matching any of them are not sent, can be repeated")
+	fs.Var(&config.DropLabels, "drop-label", "key=value label matcher of alerts that are never sent, can be repeated or comma separated")
+	fs.StringVar(&config.StatusPolicy, "status-policy", "alert", "Which status an alert is rendered with when the group and alert status disagree: alert or group")
+	fs.BoolVar(&config.GrafanaAlertSource, "grafanaAlertSource", true, "Set to false to use alerter with external alert manager")
+	fs.StringVar(&config.GrafanaUrl, "grafanaUrl", "", "URL to grafana (applicable only when grafanaAlertSource=false)")
+	fs.StringVar(&config.GrafanaApiToken, "grafana-api-token", "", "Grafana service account token used to fetch the alerts grafana truncated from a notification, from grafanaUrl or the externalURL of the notification")
+	fs.BoolVar(&config.DisableGrafanaSilenceButton, "grafanaSilenceButton", true, "Set to false to enable silence button in the alert message")
+	fs.StringVar(&config.AlertmanagerName, "alertmanager-name", "Alertmanager", "Name of the alertmanager datasource used in silence links (applicable only when grafanaAlertSource=false)")
+	fs.StringVar(&config.ExploreDatasource, "explore-datasource", "prometheus", "Datasource name used by the explore button (applicable only when grafanaAlertSource=false)")
+	fs.StringVar(&config.ExploreDatasourceUid, "explore-datasource-uid", "", "Datasource UID used by the explore button, overridden by the 'datasource_uid' alert label; switches to UID based explore links (applicable only when grafanaAlertSource=false)")
+	fs.Var(&config.FooterLinks, "footer-links", "Comma separated list of text=url links rendered in the footer of every message")
+	fs.StringVar(&config.ExternalURLText, "external-url-text", "Open Grafana", "Text of the footer link to the externalURL of the notification, e.g. ':link: Open Alertmanager'; the link is hidden when empty")
+	fs.StringVar(&config.ImageAnnotation, "image-annotation", "", "Annotation holding an image url rendered below the alert description, e.g. image_url")
+	fs.StringVar(&config.TeamLabelKey, "team-label-key", "label_app_kubernetes_io_team", "Label whose value is rendered as a slack mention by prefixing it with @, disabled when empty")
+	fs.Var(&config.LabelAnnotations, "label-annotations", "Comma separated list of annotations rendered in the labels block, prefixed with annotation:")
+	fs.Var(&config.StripLabelPrefix, "strip-label-prefix", "Comma separated list of prefixes stripped from displayed label names, e.g. label_app_kubernetes_io_; matching still uses the original names")
+	fs.Var(&config.HideLabels, "hide-labels", "Comma separated label keys left out of the rendered labels, a trailing * matches a prefix, e.g. __*")
+	fs.StringVar(&config.LabelRender, "label-render", "json", "How labels are rendered: json, keyvalue (name=value lines) or fields (slack section fields)")
+	fs.StringVar(&config.LabelNewlines, "label-newlines", "escape", "How newlines in label values are rendered: escape (as \\n) or collapse (into a single line)")
+	fs.BoolVar(&config.FiringDuration, "firing-duration", false, "Render how long a resolved alert was firing in its context")
+	fs.BoolVar(&config.RuleLink, "rule-link", false, "Render the __alert_rule_uid__ label as a link to the alert rule in grafana (requires grafanaUrl)")
+	fs.BoolVar(&config.FingerprintLink, "fingerprint-link", false, "Render the alert fingerprint as a link to the alert instance in grafana (requires grafanaUrl)")
+	fs.IntVar(&config.ValuePrecision, "value-precision", 4, "Number of significant digits of rendered alert values")
+	fs.StringVar(&config.ValueUnitStyle, "value-unit-style", "si", "Unit prefixes of rendered alert values: si (k, M, G) or binary (Ki, Mi, Gi)")
+	fs.StringVar(&config.DateFormat, "date-format", "", "Go time layout used to render start and end times, Slack localized dates are used when empty")
+	fs.StringVar(&config.Timezone, "timezone", "UTC", "Timezone used to render start and end times (applicable only when date-format is set)")
+	fs.StringVar(&config.LayoutLabel, "layout-label", "kind", "Label whose value selects the alert layout from layouts")
+	fs.Var(&config.Layouts, "layouts", "Comma separated list of label-value=layout pairs, e.g. infra=compact; available layouts: full, compact")
+	fs.Var(&config.ChannelLayouts, "channel-layouts", "Comma separated list of channel=layout pairs used for alerts without a layout label; available layouts: full, compact")
+	fs.Var(&config.StatusEmoji, "status-emoji", "Comma separated list of status=emoji pairs used in alert headers (default firing=:sos:,resolved=:large_green_circle:)")
+	fs.StringVar(&config.SeverityMapFile, "severity-map", "", "Path to YAML or JSON file mapping severities to emoji, color, channel and mention, e.g. {\"critical\": {\"emoji\": \":fire:\", \"channel\": \"#oncall\", \"mention\": \"@here\"}}")
+	fs.Var(&config.SeverityColors, "severity-colors", "Comma separated list of severity=#RRGGBB pairs used as attachment colors with color-by-severity, e.g. critical=#E01E5A,warning=#FF9900")
+	fs.Var(&config.SeverityEmoji, "severity-emoji", "Comma separated list of severity=emoji pairs used in headers of firing alerts, e.g. critical=:fire:,warning=:warning:")
+	fs.BoolVar(&config.ColorBySeverity, "color-by-severity", false, "Render every alert as an attachment colored by its severity label: critical is red, warning is orange, resolved is green and anything else is gray")
+	fs.Var(&config.SeverityOrder, "severity-order", "Comma separated list of severities alerts of a message and its preview text are ordered by, e.g. critical,warning,info")
+	fs.BoolVar(&config.DigestLine, "digest-line", false, "Render every alert as a single line of text with emoji, summary and value instead of blocks and buttons")
+	fs.IntVar(&config.SummaryThreshold, "summary-threshold", 0, "Number of alerts in a notification above which every group is posted as a single message listing one line per alert, disabled when 0")
+	fs.StringVar(&config.GroupBy, "group-by", "", "Label to group alerts into messages by instead of their status, firing and resolved alerts of a group share a message")
+	fs.BoolVar(&config.Compact, "compact", false, "Render labels shared by all alerts and the common summary once per message and only the differing labels per alert")
+	fs.BoolVar(&config.ShowCommonLabels, "show-common-labels", false, "Render labels and annotations shared by all alerts at the top of every message")
+	fs.StringVar(&config.SnoozeAlertmanagerUrl, "snooze-alertmanager-url", "", "Base URL of alertmanager v2 API used by the snooze button, e.g. https://grafana/api/alertmanager/grafana; the button is hidden when empty")
+	fs.StringVar(&config.SnoozeApiToken, "snooze-api-token", "", "Bearer token used to create silences for the snooze button")
+	fs.DurationVar(&config.SnoozeDuration, "snooze-duration", time.Hour, "Duration of the silence created by the snooze button")
+	fs.StringVar(&config.SlackSigningSecret, "slack-signing-secret", "", "Slack app signing secret used to verify interactive actions (required by the snooze button)")
+	fs.DurationVar(&config.DedupWindow, "dedup-window", 0, "Suppress a firing alert already posted within this window, e.g. on grafana repeat interval, disabled when 0")
+	fs.DurationVar(&config.ResolvedDedupWindow, "resolved-dedup-window", 0, "Suppress a resolution of an alert already posted as resolved within this window, disabled when 0")
+	fs.IntVar(&config.MaxConcurrent, "max-concurrent", 0, "Maximum number of notifications processed at once, unlimited when 0")
+	fs.DurationVar(&config.MaxConcurrentWait, "max-concurrent-wait", 5*time.Second, "How long a notification waits for a max-concurrent slot before it is rejected with 429")
+	fs.BoolVar(&config.WorkspaceBackoff, "workspace-backoff", true, "Pause all slack calls until the Retry-After of a rate limited response elapses")
+	fs.DurationVar(&config.RetryBudget, "retry-budget", 0, "Total time a notification may spend waiting to retry slack posts that failed with a rate limit, server or network error, retries are disabled when 0")
+	fs.Float64Var(&config.SlackRateLimit, "slack-rate-limit", 0, "Maximum number of slack calls per second, unlimited when 0")
+	fs.IntVar(&config.PostConcurrency, "post-concurrency", 4, "Maximum number of messages of a status group posted to slack in parallel, groups are posted one after another and 1 keeps the message order within a group")
+	fs.DurationVar(&config.ReadinessInterval, "readiness-interval", time.Minute, "How often the /ready endpoint re-checks slack connectivity, results are cached in between")
+	fs.DurationVar(&config.ReadTimeout, "read-timeout", 5*time.Second, "Maximum duration for reading a whole request")
+	fs.DurationVar(&config.WriteTimeout, "write-timeout", 30*time.Second, "Maximum duration of handling a request and writing the response, should exceed the time needed to post all messages of a notification")
+	fs.DurationVar(&config.IdleTimeout, "idle-timeout", 120*time.Second, "Maximum duration a keep-alive connection stays idle")
+	fs.DurationVar(&config.ShutdownDelay, "shutdown-delay", 5*time.Second, "How long /readyz fails before the server stops accepting requests on shutdown, so load balancers stop routing to it first")
+	fs.Int64Var(&config.MaxBodyBytes, "max-body-bytes", 4<<20, "Maximum size of an accepted webhook or slack interaction request body, larger requests are rejected with 413")
+	fs.DurationVar(&config.SlackTimeout, "slack-timeout", 10*time.Second, "Timeout of posting a single message to slack, disabled when 0")
+	fs.BoolVar(&config.UnfurlLinks, "unfurl-links", false, "Let slack unfurl links and media of alert messages into preview cards")
+	fs.BoolVar(&config.DebugHTTP, "debug-http", false, "Log full request and response bodies of failed slack calls")
+	fs.BoolVar(&config.NotifyTest, "notify-test", false, "Post a short message to the channel when a notification without alerts, like a grafana test, is received")
+	fs.BoolVar(&config.NotifyFiltered, "notify-filtered", false, "Post a short notice to the channel when the label filters drop every alert of a notification")
+	fs.BoolVar(&config.DryRun, "dry-run", false, "Log built slack messages instead of posting them")
+	fs.StringVar(&config.HmacSecret, "hmac-secret", "", "Shared secret to verify HMAC-SHA256 signature of incoming requests, verification is skipped when empty")
+	fs.StringVar(&config.HmacHeader, "hmac-header", "X-Grafana-Signature", "Header carrying hex encoded HMAC-SHA256 signature of the request body")
+	if err := fs.Parse(args); err != nil {
+		return config, configFile, err
+	}
+	if err := applyEnvFallback(fs); err != nil {
+		return config, configFile, err
+	}
+	if configFile != "" {
+		if err := loadConfig(fs, configFile, &config); err != nil {
+			return config, configFile, fmt.Errorf("failed to load config: %w", err)
+		}
 	}
-	log.Println("server stopped")
+	return config, configFile, nil
 }
 
+// validateConfig checks the config and loads the files it refers to, it is
+// used both at startup and when the config is reloaded.
+func validateConfig(config *Config) error {
+	if !config.DryRun && config.SlackBotToken == "" {
+		if config.WebhookUrl != "" || len(config.WebhookUrls) == 0 {
+			if err := validateUrl(config.WebhookUrl, true); err != nil {
+				return fmt.Errorf("invalid webhook-url: %w", err)
+			}
+		}
+		for name, webhookUrl := range config.WebhookUrls {
+			if err := validateUrl(webhookUrl, true); err != nil {
+				return fmt.Errorf("invalid '%s' webhook url: %w", name, err)
+			}
+		}
+	}
+	if !config.GrafanaAlertSource {
+		if err := validateUrl(config.GrafanaUrl, false); err != nil {
+			return fmt.Errorf("invalid grafanaUrl, it is required when grafanaAlertSource=false: %w", err)
+		}
+	}
+	if err := validateChannelPrecedence(config.ChannelPrecedence); err != nil {
+		return fmt.Errorf("invalid channel precedence: %w", err)
+	}
+	if _, _, err := net.SplitHostPort(config.ListenAddress); err != nil {
+		return fmt.Errorf("invalid listen address '%s', expected host:port: %w", config.ListenAddress, err)
+	}
+	if config.ValueUnitStyle != "si" && config.ValueUnitStyle != "binary" {
+		return fmt.Errorf("unknown value-unit-style '%s'", config.ValueUnitStyle)
+	}
+	if config.ValuePrecision < 1 {
+		return fmt.Errorf("value-precision must be positive, got %d", config.ValuePrecision)
+	}
+	if config.ReadTimeout <= 0 || config.WriteTimeout <= 0 || config.IdleTimeout <= 0 {
+		return errors.New("read-timeout, write-timeout and idle-timeout must be positive")
+	}
+	if config.ShutdownDelay < 0 {
+		return fmt.Errorf("shutdown-delay must not be negative, got %s", config.ShutdownDelay)
+	}
+	for _, sinkUrl := range config.SinkUrls {
+		if err := validateUrl(sinkUrl, false); err != nil {
+			return fmt.Errorf("invalid sink url: %w", err)
+		}
+	}
+	if config.SummaryThreshold < 0 {
+		return fmt.Errorf("summary-threshold must not be negative, got %d", config.SummaryThreshold)
+	}
+	if config.MaxBodyBytes < 1 {
+		return fmt.Errorf("max-body-bytes must be positive, got %d", config.MaxBodyBytes)
+	}
+	if config.MaxConcurrent < 0 || config.SlackRateLimit < 0 {
+		return errors.New("max-concurrent and slack-rate-limit must not be negative")
+	}
+	if config.PostConcurrency < 1 {
+		return fmt.Errorf("post-concurrency must be positive, got %d", config.PostConcurrency)
+	}
+	if config.StatusPolicy != "alert" && config.StatusPolicy != "group" {
+		return fmt.Errorf("unknown status-policy '%s'", config.StatusPolicy)
+	}
+	if config.LabelRender != "json" && config.LabelRender != "keyvalue" && config.LabelRender != "fields" {
+		return fmt.Errorf("unknown label-render mode '%s'", config.LabelRender)
+	}
+	if config.LabelNewlines != "escape" && config.LabelNewlines != "collapse" {
+		return fmt.Errorf("unknown label-newlines mode '%s'", config.LabelNewlines)
+	}
+	for severity, color := range config.SeverityColors {
+		if !hexColor.MatchString(color) {
+			return fmt.Errorf("invalid severity color '%s' for '%s', expected #RRGGBB", color, severity)
+		}
+	}
+	if config.RoutesFile != "" {
+		routes, err := loadRoutes(config.RoutesFile)
+		if err != nil {
+			return fmt.Errorf("invalid routes: %w", err)
+		}
+		config.Routes = routes
+	} else if err := config.Routes.compile(); err != nil {
+		return fmt.Errorf("invalid routes: %w", err)
+	}
+	if config.SeverityMapFile != "" {
+		severityMap, err := loadSeverityMap(config.SeverityMapFile)
+		if err != nil {
+			return fmt.Errorf("invalid severity-map: %w", err)
+		}
+		config.SeverityMap = severityMap
+	} else if err := config.SeverityMap.validate(); err != nil {
+		return fmt.Errorf("invalid severityMap: %w", err)
+	}
+	for value, layout := range config.Layouts {
+		if layout != layoutFull && layout != layoutCompact {
+			return fmt.Errorf("unknown layout '%s' for '%s' label value", layout, value)
+		}
+	}
+	for channel, layout := range config.ChannelLayouts {
+		if layout != layoutFull && layout != layoutCompact {
+			return fmt.Errorf("unknown layout '%s' for '%s' channel", layout, channel)
+		}
+	}
+	if config.SnoozeAlertmanagerUrl != "" && config.SlackSigningSecret == "" {
+		return errors.New("slack-signing-secret is required when snooze-alertmanager-url is set")
+	}
+	if config.IconEmoji != "" && config.IconURL != "" {
+		slog.Warn("both icon-emoji and icon-url are set, slack uses icon-url")
+	}
+	if len(config.EphemeralSeverities) > 0 && (config.EphemeralUser == "" || config.SlackBotToken == "") {
+		return errors.New("ephemeral-severities require ephemeral-user and slack-bot-token")
+	}
+	location, err := time.LoadLocation(config.Timezone)
+	if err != nil {
+		slog.Warn("cannot load timezone, using UTC", "timezone", config.Timezone, "err", err)
+		location = time.UTC
+	}
+	config.location = location
+	if (config.TLSCert == "") != (config.TLSKey == "") {
+		return errors.New("both tls-cert and tls-key must be set to enable TLS")
+	}
+	if config.TLSCert != "" {
+		if _, err := tls.LoadX509KeyPair(config.TLSCert, config.TLSKey); err != nil {
+			return fmt.Errorf("invalid tls-cert or tls-key: %w", err)
+		}
+	}
+	return nil
+}
+
+// normalizePathPrefix turns e.g. "alerter/" into "/alerter" and "/" into "".
+func normalizePathPrefix(prefix string) string {
+	if prefix = strings.Trim(prefix, "/"); prefix == "" {
+		return ""
+	}
+	return "/" + prefix
+}
+
+func validateUrl(value string, requireHttps bool) error {
+	if value == "" {
+		return fmt.Errorf("url is empty")
+	}
+	parsed, err := url.ParseRequestURI(value)
+	if err != nil {
+		return err
+	}
+	if parsed.Host == "" {
+		return fmt.Errorf("url '%s' has no host", value)
+	}
+	if requireHttps && parsed.Scheme != "https" {
+		return fmt.Errorf("url '%s' is not https", value)
+	}
+	return nil
+}
+
+func newLogger(format string, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	}
+	return nil, fmt.Errorf("unknown log format '%s'", format)
+}
+
+// apiClient calls sinks, grafana and alertmanager, it has a transport of its
+// own since main wraps http.DefaultTransport to log slack responses.
+var apiClient = &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()}
+
+// LoggingRoundTripper logs failed requests, full bodies are dumped only in Debug
+// mode since they carry channel names and alert text.
 type LoggingRoundTripper struct {
 	Proxied http.RoundTripper
+	Debug   bool
 }
 
 func (l LoggingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
-	reqDump, _ := httputil.DumpRequest(req, true)
+	var reqDump []byte
+	if l.Debug {
+		reqDump, _ = httputil.DumpRequest(req, true)
+	}
 	res, err := l.Proxied.RoundTrip(req)
 	if res == nil {
-		log.Println("nil res", err)
+		slog.Error("no response from slack", "err", err)
 	} else if res.StatusCode != http.StatusOK {
-		resDump, _ := httputil.DumpResponse(res, true)
-		log.Println("err request", string(reqDump))
-		log.Println("err response", string(resDump))
+		if l.Debug {
+			resDump, _ := httputil.DumpResponse(res, true)
+			slog.Error("unexpected slack response", "status", res.StatusCode, "request", string(reqDump), "response", string(resDump))
+		} else {
+			// webhook paths carry the webhook secret, only the host is reported
+			slog.Error("unexpected slack response", "status", res.StatusCode, "method", req.Method, "host", req.URL.Host)
+		}
 	}
 	return res, err
 }
 
-func handleWebhookRequest(w http.ResponseWriter, r *http.Request) {
-	channel := r.URL.Query().Get("channel")
-	if channel == "" {
-		channel = "alerts"
-		log.Println("slack channel is not specified in 'channel' query param, using default 'alerts' channel")
+// Handler converts grafana webhook requests into slack messages according to its config.
+type Handler struct {
+	config      Config
+	resolved    *seenCache
+	firing      *seenCache
+	slackClient *slack.Client
+	messages    *messageStore
+	probe       *slackProbe
+	inflight    chan struct{}
+	limiter     *rate.Limiter
+	location    *time.Location
+	// pausedUntil is the unix nano time until slack calls back off after a 429
+	pausedUntil atomic.Int64
+	// ready is set once the config is validated and cleared when shutdown begins
+	ready atomic.Bool
+}
+
+func newHandler(config Config) *Handler {
+	h := &Handler{
+		config:   config,
+		resolved: newSeenCache(config.ResolvedDedupWindow),
+		firing:   newSeenCache(config.DedupWindow),
+		messages: newMessageStore(),
+		probe:    newSlackProbe(config.ReadinessInterval),
+		location: config.location,
+	}
+	if h.location == nil {
+		h.location = time.UTC
+	}
+	if config.SlackBotToken != "" {
+		h.slackClient = slack.New(config.SlackBotToken)
+	}
+	if config.MaxConcurrent > 0 {
+		h.inflight = make(chan struct{}, config.MaxConcurrent)
+	}
+	if config.SlackRateLimit > 0 {
+		h.limiter = rate.NewLimiter(rate.Limit(config.SlackRateLimit), max(1, int(config.SlackRateLimit)))
+	}
+	return h
+}
+
+func (h *Handler) handleWebhookRequest(w http.ResponseWriter, r *http.Request) {
+	if h.inflight != nil {
+		timer := time.NewTimer(h.config.MaxConcurrentWait)
+		select {
+		case h.inflight <- struct{}{}:
+			timer.Stop()
+			defer func() { <-h.inflight }()
+		case <-timer.C:
+			slog.Warn("too many notifications in flight", "limit", h.config.MaxConcurrent)
+			http.Error(w, "too many notifications in flight", http.StatusTooManyRequests)
+			return
+		case <-r.Context().Done():
+			timer.Stop()
+			return
+		}
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.config.MaxBodyBytes))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		slog.Warn("request body is too large", "limit", tooLarge.Limit)
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
 	}
-	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Println(err)
+		slog.Error("failed to read request body", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if h.config.HmacSecret != "" && !h.validSignature(body, r.Header.Get(h.config.HmacHeader)) {
+		slog.Warn("request signature does not match", "header", h.config.HmacHeader)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
 	grafanaMsg := GrafanaMsg{}
 	if err := json.Unmarshal(body, &grafanaMsg); err != nil {
-		log.Println(err)
+		slog.Error("failed to parse grafana message", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	slackMsgs := buildMessages(grafanaMsg, channel)
+	webhookUrl, err := h.resolveWebhook(r)
+	if err != nil {
+		slog.Warn("failed to resolve slack webhook", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if grafanaMsg.TruncatedAlerts > 0 && h.config.GrafanaApiToken != "" {
+		if err := h.fetchTruncatedAlerts(r.Context(), &grafanaMsg); err != nil {
+			slog.Warn("failed to fetch truncated alerts from grafana", "err", err, "truncated", grafanaMsg.TruncatedAlerts)
+		}
+	}
+
+	h.reconcileStatus(&grafanaMsg)
+
+	channel, source := h.resolveChannel(r, grafanaMsg)
+	if source == "default" {
+		slog.Info("slack channel is not resolved by any source, using default channel", "sources", h.config.ChannelPrecedence, "channel", channel)
+	}
+
+	if len(grafanaMsg.Alerts) == 0 {
+		slog.Info("notification has no alerts", "receiver", grafanaMsg.Receiver, "status", grafanaMsg.Status)
+		if h.config.NotifyTest {
+			testMsg := SlackMsg{WebhookMessage: slack.WebhookMessage{
+				Username:  h.username(grafanaMsg),
+				IconEmoji: h.config.IconEmoji,
+				IconURL:   h.config.IconURL,
+				Channel:   channel,
+				Text:      ":white_check_mark: Grafana test notification received",
+			}, WebhookUrl: webhookUrl}
+			if err := h.post(r.Context(), nil, grafanaMsg, &testMsg); err != nil {
+				slog.Error("failed to post to slack", "err", err, "channel", channel)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("no alerts to process"))
+		return
+	}
+
+	if len(h.config.IncludeLabels) > 0 || len(h.config.ExcludeLabels) > 0 || len(h.config.DropLabels) > 0 {
+		alertsCount := len(grafanaMsg.Alerts)
+		grafanaMsg.Alerts = h.filterAlerts(grafanaMsg.Alerts)
+		if alertsCount > 0 && len(grafanaMsg.Alerts) == 0 {
+			slog.Info("all alerts of the notification were filtered out", "count", alertsCount)
+			if h.config.NotifyFiltered {
+				noticeMsg := SlackMsg{WebhookMessage: slack.WebhookMessage{
+					Username:  h.username(grafanaMsg),
+					IconEmoji: h.config.IconEmoji,
+					IconURL:   h.config.IconURL,
+					Channel:   channel,
+					Text:      fmt.Sprintf(":see_no_evil: All %d alerts of a notification from %s were filtered out", alertsCount, grafanaMsg.Receiver),
+				}, WebhookUrl: webhookUrl}
+				if err := h.post(r.Context(), nil, grafanaMsg, &noticeMsg); err != nil {
+					slog.Error("failed to post to slack", "err", err, "channel", channel)
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+
+	var resolvedKeys []string
+	if h.config.ResolvedDedupWindow > 0 {
+		alertsCount := len(grafanaMsg.Alerts)
+		grafanaMsg.Alerts, resolvedKeys = h.dropRepeatedResolutions(grafanaMsg.Alerts)
+		if dropped := alertsCount - len(grafanaMsg.Alerts); dropped > 0 {
+			slog.Info("suppressed repeated resolutions", "count", dropped)
+		}
+	}
 
-	var lastError error
-	for _, slackMsg := range slackMsgs {
-		if err := slack.PostWebhookContext(r.Context(), webhookUrl, &slackMsg); err != nil {
-			lastError = err
-			log.Println(err)
+	var firingKeys []string
+	if h.config.DedupWindow > 0 {
+		alertsCount := len(grafanaMsg.Alerts)
+		grafanaMsg.Alerts, firingKeys = h.dropRepeatedFirings(grafanaMsg.Alerts)
+		if dropped := alertsCount - len(grafanaMsg.Alerts); dropped > 0 {
+			slog.Info("suppressed repeated firing alerts", "count", dropped)
 		}
 	}
-	if lastError != nil {
-		http.Error(w, lastError.Error(), http.StatusInternalServerError)
+
+	if h.slackClient != nil && h.config.UpdateResolved && !h.config.DryRun {
+		grafanaMsg.Alerts = h.updateResolvedMessages(r.Context(), grafanaMsg)
+	}
+
+	slackMsgs := h.buildMessages(grafanaMsg, channel)
+	for i := range slackMsgs {
+		slackMsgs[i].WebhookUrl = webhookUrl
+	}
+
+	if err := h.postAll(r.Context(), grafanaMsg, slackMsgs, channel); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 	} else {
+		h.resolved.add(resolvedKeys...)
+		h.firing.add(firingKeys...)
 		w.WriteHeader(http.StatusOK)
 	}
 }
 
-func buildMessages(msg GrafanaMsg, channel string) []slack.WebhookMessage {
-	var messages []slack.WebhookMessage
+// postAll posts the messages group after group, so firing messages land above
+// resolved ones in the channel, and returns the joined errors of every failed post.
+func (h *Handler) postAll(ctx context.Context, grafanaMsg GrafanaMsg, msgs []SlackMsg, channel string) error {
+	var errs []error
+	budget := &retryBudget{remaining: h.config.RetryBudget}
+	for start := 0; start < len(msgs) && ctx.Err() == nil; {
+		end := start + 1
+		for end < len(msgs) && msgs[end].Group == msgs[start].Group {
+			end++
+		}
+		if err := h.postGroup(ctx, budget, grafanaMsg, msgs[start:end], channel); err != nil {
+			errs = append(errs, err)
+		}
+		start = end
+	}
+	return errors.Join(errs...)
+}
 
-	alertsByStatus := groupByStatus(msg)
+// postGroup posts the messages of a group with at most post-concurrency
+// requests in flight and returns the joined errors of every failed post.
+func (h *Handler) postGroup(ctx context.Context, budget *retryBudget, grafanaMsg GrafanaMsg, msgs []SlackMsg, channel string) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	slots := make(chan struct{}, h.config.PostConcurrency)
+	for i := range msgs {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return errors.Join(append(errs, ctx.Err())...)
+		}
+		wg.Add(1)
+		go func(msg *SlackMsg) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			if err := h.post(ctx, budget, grafanaMsg, msg); err != nil {
+				slog.Error("failed to post to slack", "err", err, "channel", channel)
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(&msgs[i])
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
 
-	for _, groupedAlerts := range alertsByStatus {
+// post delivers the message to slack and the sinks, failed slack posts are
+// retried while the budget allows, no retries happen with a nil budget. Sink
+// failures are only logged: failing the webhook would make grafana send the
+// notification again and duplicate the messages slack already accepted.
+func (h *Handler) post(ctx context.Context, budget *retryBudget, grafanaMsg GrafanaMsg, msg *SlackMsg) error {
+	if h.config.DryRun {
+		msgJson, err := json.MarshalIndent(msg, "", "  ")
+		if err != nil {
+			return err
+		}
+		slog.Info("dry run, not posting to slack", "channel", msg.Channel, "message", string(msgJson))
+		return nil
+	}
+	err := h.postToSlackWithRetry(ctx, budget, grafanaMsg, msg)
+	if sinkErr := h.postToSinks(ctx, msg); sinkErr != nil {
+		slog.Error("failed to post to sinks", "err", sinkErr, "channel", msg.Channel)
+	}
+	return err
+}
 
-		chunkedAlerts := chunkBy(groupedAlerts, 7)
+func (h *Handler) postToSlack(ctx context.Context, grafanaMsg GrafanaMsg, msg *SlackMsg) error {
+	if err := h.throttle(ctx); err != nil {
+		return err
+	}
+	ctx, cancel := h.slackContext(ctx)
+	defer cancel()
+	var err error
+	if h.slackClient != nil {
+		err = h.postWithToken(ctx, grafanaMsg, msg)
+	} else {
+		err = postWebhook(ctx, msg.WebhookUrl, msg)
+	}
+	h.backOff(err)
+	return err
+}
 
-		for _, alerts := range chunkedAlerts {
+// throttle waits until a workspace backoff is over and slack-rate-limit
+// allows the next slack call.
+func (h *Handler) throttle(ctx context.Context) error {
+	if wait := time.Until(time.Unix(0, h.pausedUntil.Load())); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if h.limiter == nil {
+		return nil
+	}
+	return h.limiter.Wait(ctx)
+}
 
-			var firedText string
-			var resolvedText string
-			var blocks []slack.Block
+// backOff pauses every slack call until the Retry-After of a rate limited
+// response elapses, slack limits the whole workspace and not a single message.
+func (h *Handler) backOff(err error) {
+	var rateLimited *slack.RateLimitedError
+	if !h.config.WorkspaceBackoff || !errors.As(err, &rateLimited) {
+		return
+	}
+	until := time.Now().Add(rateLimited.RetryAfter).UnixNano()
+	for {
+		current := h.pausedUntil.Load()
+		if current >= until || h.pausedUntil.CompareAndSwap(current, until) {
+			break
+		}
+	}
+	slog.Warn("slack rate limited the workspace, pausing slack calls", "for", rateLimited.RetryAfter)
+}
 
-			for i, alert := range alerts {
-				var summary string
-				if alert.Status != "resolved" {
-					summary = ":sos: " + alert.Annotations["summary"]
-					firedText = fmt.Sprintf("%s[%s] ", firedText, alert.Annotations["summary"])
-				} else {
-					summary = ":large_green_circle: " + alert.Annotations["summary"]
-					resolvedText = fmt.Sprintf("%s[%s] ", resolvedText, alert.Annotations["summary"])
-				}
+// slackContext bounds a single slack call by the slack-timeout.
+func (h *Handler) slackContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if h.config.SlackTimeout > 0 {
+		return context.WithTimeout(ctx, h.config.SlackTimeout)
+	}
+	return context.WithCancel(ctx)
+}
 
-				var buttons []slack.BlockElement
-
-				generatorButton := slack.NewButtonBlockElement("generator", "", slack.NewTextBlockObject("plain_text", ":information_source: Details", true, false))
-				if grafanaAlertSource {
-					generatorButton.URL = alert.GeneratorURL
-				} else {
-					var labels []string
-					for k, v := range alert.Labels {
-						labels = append(labels, fmt.Sprintf(`%s="%s"`, k, v))
-					}
-					query := fmt.Sprintf("{%s}", strings.Join(labels, ","))
-					generatorButton.URL = fmt.Sprintf("%s/alerting/list?queryString=%s&ruleType=alerting", grafanaUrl, url.QueryEscape(query))
-				}
-				generatorButton.Style = slack.StylePrimary
-				buttons = append(buttons, generatorButton)
-
-				if !grafanaAlertSource {
-					parsed, err := url.ParseRequestURI(strings.TrimSuffix(alert.GeneratorURL, `\u0026g0.tab=1`))
-					if err != nil {
-						log.Println(err)
-					} else {
-						exploreButton := slack.NewButtonBlockElement("explore", "", slack.NewTextBlockObject("plain_text", ":chart_with_upwards_trend: Explore", true, false))
-						expStr := fmt.Sprintf(`{"datasource":"prometheus","queries":[{"datasource":"Prometheus","expr":"%s","refId":"A"}],"range":{"from":"now-1h","to":"now"}}`, strings.ReplaceAll(parsed.Query().Get("g0.expr"), `"`, `\"`))
-						exploreButton.URL = fmt.Sprintf("%s/explore?left=%s", grafanaUrl, url.QueryEscape(expStr))
-						exploreButton.Style = slack.StylePrimary
-						buttons = append(buttons, exploreButton)
-					}
-				}
+// defaultRetryAfter is the wait of rate limited slack calls without a valid,
+// positive Retry-After header.
+const defaultRetryAfter = 5 * time.Second
 
-				if alert.Status != "resolved" {
-					if runbookUrl, ok := alert.Annotations["runbook_url"]; ok && runbookUrl != "" {
-						runbookButton := slack.NewButtonBlockElement("runbook", "", slack.NewTextBlockObject("plain_text", ":page_with_curl: Runbook", true, false))
-						runbookButton.URL = runbookUrl
-						runbookButton.Style = slack.StyleDefault
-						buttons = append(buttons, runbookButton)
-					}
-				}
+// postWebhook mirrors slack.PostWebhookContext for payloads with fields
+// missing from slack.WebhookMessage.
+func postWebhook(ctx context.Context, webhookUrl string, msg *SlackMsg) error {
+	raw, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("marshal failed: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookUrl, bytes.NewReader(raw))
+	if err != nil {
+		return fmt.Errorf("failed new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post webhook: %w", err)
+	}
+	defer res.Body.Close()
+	// slack answers with a short reason like channel_not_found or invalid_blocks
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	reason := strings.TrimSpace(string(body))
+	if res.StatusCode == http.StatusTooManyRequests {
+		retryAfter := defaultRetryAfter
+		if retry, err := strconv.ParseInt(res.Header.Get("Retry-After"), 10, 64); err == nil && retry > 0 {
+			retryAfter = time.Duration(retry) * time.Second
+		}
+		return &slack.RateLimitedError{RetryAfter: retryAfter}
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s", slack.StatusCodeError{Code: res.StatusCode, Status: res.Status}, reason)
+	}
+	if reason != "ok" {
+		return fmt.Errorf("slack did not accept the message: %s", reason)
+	}
+	return nil
+}
 
-				if alert.Status != "resolved" && !disableGrafanaSilenceButton {
-					silenceButton := slack.NewButtonBlockElement("silence", "", slack.NewTextBlockObject("plain_text", ":no_bell: Silence", true, false))
-					if grafanaAlertSource {
-						silenceButton.URL = alert.SilenceURL
-					} else {
-						var matchers []string
-						for k, v := range alert.Labels {
-							matcher := fmt.Sprintf("%s=%s", k, v)
-							matchers = append(matchers, fmt.Sprintf(`matcher=%s`, url.QueryEscape(matcher)))
-						}
-						silenceButton.URL = fmt.Sprintf("%s/alerting/silence/new?alertmanager=Alertmanager&%s", grafanaUrl, strings.Join(matchers, "&"))
-					}
-					silenceButton.Style = slack.StyleDanger
-					buttons = append(buttons, silenceButton)
-				}
+// reconcileStatus applies the status policy to the alerts of the message:
+//
+//	alert - every alert keeps its own status, which is what grafana reports per alert
+//	group - every alert takes the status of the whole group, e.g. a firing group
+//	        with some resolved alerts is rendered as firing altogether
+func (h *Handler) reconcileStatus(msg *GrafanaMsg) {
+	if h.config.StatusPolicy != "group" || msg.Status == "" {
+		return
+	}
+	for i := range msg.Alerts {
+		msg.Alerts[i].Status = msg.Status
+	}
+}
 
-				var contextElements []slack.MixedElement
-				if alert.ValueString != "" {
-					contextElements = append(contextElements, slack.NewTextBlockObject("plain_text", fmt.Sprintf("Value: %s", extractValue(alert.ValueString)), true, false))
-				}
-				contextElements = append(contextElements, slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("<!date^%d^Started at: {date_num} {time_secs}|_>", alert.StartsAt.Unix()), false, false))
-				if !alert.EndsAt.IsZero() {
-					contextElements = append(contextElements, slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("<!date^%d^Ended at: {date_num} {time_secs}|_>", alert.EndsAt.Unix()), false, false))
-				}
+func (h *Handler) validSignature(body []byte, signature string) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(h.config.HmacSecret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
 
-				if i != 0 {
-					blocks = append(blocks, slack.NewDividerBlock())
-				}
+// SlackMsg is a slack message together with the alerts rendered into it.
+type SlackMsg struct {
+	slack.WebhookMessage
+	// slack.WebhookMessage has no unfurl fields although incoming webhooks honor them
+	UnfurlLinks bool    `json:"unfurl_links"`
+	UnfurlMedia bool    `json:"unfurl_media"`
+	Alerts      []Alert `json:"-"`
+	// WebhookUrl is the incoming webhook the message is posted to when no bot token is set
+	WebhookUrl string `json:"-"`
+	// Group is the status or group-by label value the message was built for
+	Group string `json:"-"`
+	// Digest and Summary mark messages rendered as one line per alert or as a
+	// summary, bot mode updates them accordingly
+	Digest  bool `json:"-"`
+	Summary bool `json:"-"`
+}
 
-				blocks = append(blocks, slack.NewHeaderBlock(slack.NewTextBlockObject("plain_text", summary, true, false)))
+func (h *Handler) buildMessages(msg GrafanaMsg, channel string) []SlackMsg {
+	var messages []SlackMsg
 
-				if description, ok := alert.Annotations["description"]; ok && description != "" {
-					blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", description, false, false), nil, nil))
-				}
+	var alertsByGroup map[string][]Alert
+	var groups []string
+	if h.config.GroupBy != "" {
+		alertsByGroup = groupByLabel(msg, h.config.GroupBy)
+		groups = sortedGroups(alertsByGroup)
+	} else {
+		alertsByGroup = groupByStatus(msg)
+		groups = sortedStatuses(alertsByGroup)
+	}
 
-				for name, value := range alert.Labels {
-					if name == "label_app_kubernetes_io_team" {
-						alert.Labels[name] = "@" + value
-					}
-				}
-				labelsJson, err := json.Marshal(alert.Labels)
-				if err != nil {
-					log.Println(err)
-					labelsJson = []byte{}
-				}
-				labelsStr := string(labelsJson)
-				labelsStr = strings.ReplaceAll(strings.ReplaceAll(labelsStr, `":"`, `": "`), `","`, `", "`)
-				blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("```%s```", labelsStr), false, false), nil, nil))
+	summarize := h.config.SummaryThreshold > 0 && len(msg.Alerts) > h.config.SummaryThreshold
 
-				blocks = append(blocks, slack.NewActionBlock(fmt.Sprintf("actions-%s", hash(alert.Labels)), buttons...))
-				blocks = append(blocks, slack.NewContextBlock(fmt.Sprintf("context-%s", hash(alert.Labels)), contextElements...))
-			}
+	for _, group := range groups {
+		alerts := h.sortBySeverity(alertsByGroup[group])
 
-			var previewText string
-			if firedText != "" {
-				previewText = fmt.Sprintf("Fired: %s", firedText)
-			} else if resolvedText != "" {
-				previewText = fmt.Sprintf("Resolved: %s", resolvedText)
+		var groupMessages []SlackMsg
+		switch {
+		case h.config.DigestLine:
+			groupMessages = append(groupMessages, h.buildDigestMessage(msg, alerts, channel))
+		case summarize:
+			groupMessages = append(groupMessages, h.buildSummaryMessage(msg, group, alerts, channel))
+		default:
+			for _, chunk := range chunkBy(alerts, 7) {
+				groupMessages = append(groupMessages, h.buildMessage(msg, chunk, channel))
 			}
+		}
+		for i := range groupMessages {
+			groupMessages[i].Group = group
+		}
+		messages = append(messages, groupMessages...)
+	}
 
-			messages = append(messages, slack.WebhookMessage{
-				Username: username,
-				Channel:  channel,
-				Text:     previewText,
-				Blocks:   &slack.Blocks{BlockSet: blocks},
-			})
+	if msg.TruncatedAlerts > 0 && len(messages) > 0 {
+		last := &messages[len(messages)-1]
+		text := fmt.Sprintf(":warning: %d additional alerts were truncated by Grafana", msg.TruncatedAlerts)
+		if last.Blocks == nil {
+			last.Text += "\n" + text
+		} else {
+			last.Blocks.BlockSet = append(last.Blocks.BlockSet, slack.NewContextBlock("truncated", slack.NewTextBlockObject("mrkdwn", text, false, false)))
 		}
 	}
 
+	for i := range messages {
+		h.addMentions(&messages[i])
+	}
+
 	return messages
 }
 
+// addMentions puts the mentions of the severities of firing alerts in front of
+// the message, the preview text carries them too so that slack notifies.
+func (h *Handler) addMentions(msg *SlackMsg) {
+	var mentions []string
+	for _, alert := range msg.Alerts {
+		if alert.Status == "resolved" {
+			continue
+		}
+		mention := slackMention(h.config.SeverityMap[alert.Labels["severity"]].Mention)
+		if mention != "" && !slices.Contains(mentions, mention) {
+			mentions = append(mentions, mention)
+		}
+	}
+	if len(mentions) == 0 {
+		return
+	}
+	text := strings.Join(mentions, " ")
+	msg.Text = text + " " + msg.Text
+	if msg.Blocks != nil {
+		section := slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", text, false, false), nil, nil)
+		msg.Blocks.BlockSet = append([]slack.Block{section}, msg.Blocks.BlockSet...)
+	}
+}
+
+// slackMention turns the special @here, @channel and @everyone mentions into
+// the syntax slack notifies on, anything else is expected in slack syntax.
+func slackMention(mention string) string {
+	switch mention {
+	case "@here", "@channel", "@everyone":
+		return "<!" + mention[1:] + ">"
+	}
+	return mention
+}
+
+func (h *Handler) buildMessage(msg GrafanaMsg, alerts []Alert, channel string) SlackMsg {
+	var blocks []slack.Block
+	var attachments []slack.Attachment
+
+	var commonLabels map[string]string
+	if h.config.Compact {
+		commonLabels = msg.CommonLabels
+		blocks = append(blocks, h.buildCompactHeader(msg)...)
+	} else if h.config.ShowCommonLabels {
+		blocks = append(blocks, h.buildCommonBlocks(msg)...)
+	}
+
+	for i, alert := range alerts {
+		summary := h.headerEmoji(alert) + " " + alertSummary(alert)
+
+		alertBlocks := h.buildAlertBlocks(alert, i, msg.OrgID, summary, commonLabels, h.layoutFor(alert, channel))
+		if h.config.ColorBySeverity {
+			attachments = append(attachments, slack.Attachment{
+				Color:  h.severityColor(alert),
+				Blocks: slack.Blocks{BlockSet: alertBlocks},
+			})
+			continue
+		}
+
+		if i != 0 {
+			blocks = append(blocks, slack.NewDividerBlock())
+		}
+		blocks = append(blocks, alertBlocks...)
+	}
+
+	if footer := buildFooter(h.config.FooterLinks, msg.ExternalURL, h.config.ExternalURLText); footer != nil {
+		blocks = append(blocks, footer)
+	}
+
+	return h.newSlackMsg(msg, alerts, channel, blocks, attachments)
+}
+
+// buildSummaryMessage lists every alert of the group on a single line instead
+// of rendering its blocks, used once a notification exceeds summary-threshold.
+func (h *Handler) buildSummaryMessage(msg GrafanaMsg, group string, alerts []Alert, channel string) SlackMsg {
+	title := strings.Join(strings.Fields(fmt.Sprintf("%d %s alerts", len(alerts), group)), " ")
+	blocks := []slack.Block{slack.NewHeaderBlock(slack.NewTextBlockObject("plain_text", title, true, false))}
+
+	// section texts are limited to 3000 characters
+	var text string
+	for _, alert := range alerts {
+		line := h.headerEmoji(alert) + " " + alertSummary(alert)
+		if alert.GeneratorURL != "" {
+			line = fmt.Sprintf("%s <%s|details>", line, alert.GeneratorURL)
+		}
+		if text != "" && len(text)+len(line)+1 > 3000 {
+			blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", text, false, false), nil, nil))
+			text = ""
+		}
+		if text != "" {
+			text += "\n"
+		}
+		text += line
+	}
+	blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", text, false, false), nil, nil))
+
+	if footer := buildFooter(h.config.FooterLinks, msg.ExternalURL, h.config.ExternalURLText); footer != nil {
+		blocks = append(blocks, footer)
+	}
+
+	slackMsg := h.newSlackMsg(msg, alerts, channel, blocks, nil)
+	slackMsg.Summary = true
+	return slackMsg
+}
+
+// buildDigestMessage renders every alert of the group as a single line of
+// plain text without any blocks, for channels that only need awareness.
+func (h *Handler) buildDigestMessage(msg GrafanaMsg, alerts []Alert, channel string) SlackMsg {
+	var lines []string
+	for _, alert := range alerts {
+		line := h.headerEmoji(alert) + " " + alertSummary(alert)
+		if alert.ValueString != "" {
+			line = fmt.Sprintf("%s (%s)", line, extractValue(alert.ValueString, h.config.ValuePrecision, h.config.ValueUnitStyle))
+		}
+		lines = append(lines, line)
+	}
+	slackMsg := h.newSlackMsg(msg, alerts, channel, nil, nil)
+	slackMsg.Text = strings.Join(lines, "\n")
+	slackMsg.Blocks = nil
+	slackMsg.Digest = true
+	return slackMsg
+}
+
+func (h *Handler) newSlackMsg(msg GrafanaMsg, alerts []Alert, channel string, blocks []slack.Block, attachments []slack.Attachment) SlackMsg {
+	return SlackMsg{
+		WebhookMessage: slack.WebhookMessage{
+			Username:    h.username(msg),
+			IconEmoji:   h.config.IconEmoji,
+			IconURL:     h.config.IconURL,
+			Channel:     channel,
+			Text:        previewText(alerts),
+			Blocks:      &slack.Blocks{BlockSet: blocks},
+			Attachments: attachments,
+		},
+		UnfurlLinks: h.config.UnfurlLinks,
+		UnfurlMedia: h.config.UnfurlLinks,
+		Alerts:      alerts,
+	}
+}
+
+// previewText is shown in notifications, it lists the summaries of the firing
+// alerts or of the resolved ones when nothing fires.
+func previewText(alerts []Alert) string {
+	var firedText string
+	var resolvedText string
+	for _, alert := range alerts {
+		if alert.Status != "resolved" {
+			firedText = fmt.Sprintf("%s[%s] ", firedText, alertSummary(alert))
+		} else {
+			resolvedText = fmt.Sprintf("%s[%s] ", resolvedText, alertSummary(alert))
+		}
+	}
+	if firedText != "" {
+		return fmt.Sprintf("Fired: %s", firedText)
+	} else if resolvedText != "" {
+		return fmt.Sprintf("Resolved: %s", resolvedText)
+	}
+	return ""
+}
+
+func (h *Handler) username(msg GrafanaMsg) string {
+	if h.config.UsernameFromReceiver && msg.Receiver != "" {
+		return msg.Receiver
+	}
+	return h.config.Username
+}
+
+var defaultStatusEmoji = map[string]string{
+	"firing":   ":sos:",
+	"resolved": ":large_green_circle:",
+}
+
+// alertSummary is the summary annotation of the alert, falling back to the
+// alert name for rules without one so the header is never empty.
+func alertSummary(alert Alert) string {
+	for _, summary := range []string{alert.Annotations["summary"], alert.Annotations["alertname"], alert.Labels["alertname"]} {
+		if summary != "" {
+			return summary
+		}
+	}
+	return "Alert"
+}
+
+// headerEmoji looks up the emoji of a firing alert by its severity label first
+// and falls back to the emoji of its status, unknown statuses count as firing.
+func (h *Handler) headerEmoji(alert Alert) string {
+	status := alert.Status
+	if status != "resolved" {
+		if emoji, ok := h.config.SeverityEmoji[alert.Labels["severity"]]; ok {
+			return emoji
+		}
+		if style := h.config.SeverityMap[alert.Labels["severity"]]; style.Emoji != "" {
+			return style.Emoji
+		}
+		status = "firing"
+	}
+	if emoji, ok := h.config.StatusEmoji[status]; ok {
+		return emoji
+	}
+	return defaultStatusEmoji[status]
+}
+
+func (h *Handler) severityColor(alert Alert) string {
+	if alert.Status == "resolved" {
+		return "#2EB67D"
+	}
+	if color, ok := h.config.SeverityColors[alert.Labels["severity"]]; ok {
+		return color
+	}
+	if style := h.config.SeverityMap[alert.Labels["severity"]]; style.Color != "" {
+		return style.Color
+	}
+	switch alert.Labels["severity"] {
+	case "critical":
+		return "#E01E5A"
+	case "warning":
+		return "#FF9900"
+	}
+	return "#9E9E9E"
+}
+
+const (
+	// layoutFull renders header, description, labels, buttons and context of an alert
+	layoutFull = "full"
+	// layoutCompact is the full layout without the labels block
+	layoutCompact = "compact"
+)
+
+// buildAlertBlocks renders a single alert, labels present in commonLabels with
+// the same value are left out since they are rendered once per message. The
+// index of the alert within the message keeps block IDs unique when alerts
+// share their labels.
+func (h *Handler) buildAlertBlocks(alert Alert, index int, orgID int64, summary string, commonLabels map[string]string, layout string) []slack.Block {
+	buttons := h.buildButtons(alert, orgID)
+	contextElements := h.buildContext(alert, orgID)
+
+	var blocks []slack.Block
+
+	blocks = append(blocks, slack.NewHeaderBlock(slack.NewTextBlockObject("plain_text", summary, true, false)))
+
+	if description, ok := alert.Annotations["description"]; ok && description != "" {
+		blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", description, false, false), nil, nil))
+	}
+
+	if imageUrl := alert.Annotations[h.config.ImageAnnotation]; h.config.ImageAnnotation != "" && imageUrl != "" {
+		blocks = append(blocks, slack.NewImageBlock(imageUrl, alertSummary(alert), "", nil))
+	}
+
+	displayLabels := map[string]string{}
+	for name, value := range alert.Labels {
+		if common, ok := commonLabels[name]; ok && common == value {
+			continue
+		}
+		if h.hiddenLabel(name) {
+			continue
+		}
+		if name == h.config.TeamLabelKey {
+			value = "@" + value
+		}
+		displayLabels[h.displayName(name, alert.Labels)] = value
+	}
+	// merged annotations are prefixed to tell them apart from labels
+	for _, name := range h.config.LabelAnnotations {
+		if value, ok := alert.Annotations[name]; ok && value != "" {
+			displayLabels["annotation:"+name] = value
+		}
+	}
+	if layout != layoutCompact && len(displayLabels) > 0 {
+		blocks = append(blocks, h.labelsBlocks(displayLabels)...)
+	}
+
+	blocks = append(blocks, slack.NewActionBlock(fmt.Sprintf("actions-%s-%d", blockKey(alert), index), buttons...))
+	blocks = append(blocks, slack.NewContextBlock(fmt.Sprintf("context-%s-%d", blockKey(alert), index), contextElements...))
+
+	return blocks
+}
+
+// blockKey identifies the blocks of an alert, alerts without labels would all
+// share the hash of no labels, so their fingerprint or content is used instead.
+func blockKey(alert Alert) string {
+	if len(alert.Labels) > 0 {
+		return hash(alert.Labels)
+	}
+	if alert.Fingerprint != "" {
+		return alert.Fingerprint
+	}
+	content := map[string]string{"startsAt": alert.StartsAt.String()}
+	for name, value := range alert.Annotations {
+		content["annotation_"+name] = value
+	}
+	return hash(content)
+}
+
+// layoutFor picks the layout mapped to the value of the alert's layout label,
+// falling back to the layout of the channel and then to the full layout.
+func (h *Handler) layoutFor(alert Alert, channel string) string {
+	if h.config.LayoutLabel != "" {
+		if layout, ok := h.config.Layouts[alert.Labels[h.config.LayoutLabel]]; ok {
+			return layout
+		}
+	}
+	if layout, ok := h.config.ChannelLayouts[channel]; ok {
+		return layout
+	}
+	return layoutFull
+}
+
+func (h *Handler) buildCompactHeader(msg GrafanaMsg) []slack.Block {
+	var blocks []slack.Block
+	if summary := msg.CommonAnnotations["summary"]; summary != "" {
+		blocks = append(blocks, slack.NewHeaderBlock(slack.NewTextBlockObject("plain_text", summary, true, false)))
+	}
+	if len(msg.CommonLabels) > 0 {
+		blocks = append(blocks, h.labelsBlocks(h.visibleLabels(msg.CommonLabels))...)
+	}
+	if len(blocks) > 0 {
+		blocks = append(blocks, slack.NewDividerBlock())
+	}
+	return blocks
+}
+
+func (h *Handler) buildCommonBlocks(msg GrafanaMsg) []slack.Block {
+	var texts []string
+	if len(msg.CommonLabels) > 0 {
+		texts = append(texts, fmt.Sprintf("*Common labels*\n```%s```", h.formatLabels(h.visibleLabels(msg.CommonLabels))))
+	}
+	if len(msg.CommonAnnotations) > 0 {
+		texts = append(texts, fmt.Sprintf("*Common annotations*\n```%s```", h.formatLabels(msg.CommonAnnotations)))
+	}
+	if len(texts) == 0 {
+		return nil
+	}
+	return []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", strings.Join(texts, "\n"), false, false), nil, nil),
+		slack.NewDividerBlock(),
+	}
+}
+
+// hiddenLabel reports whether the label is listed in hide-labels, entries
+// ending with * hide every label with that prefix, e.g. __* hides __name__.
+func (h *Handler) hiddenLabel(name string) bool {
+	for _, hidden := range h.config.HideLabels {
+		if prefix, ok := strings.CutSuffix(hidden, "*"); ok && strings.HasPrefix(name, prefix) {
+			return true
+		}
+		if hidden == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) visibleLabels(labels map[string]string) map[string]string {
+	visible := map[string]string{}
+	for name, value := range labels {
+		if !h.hiddenLabel(name) {
+			visible[h.displayName(name, labels)] = value
+		}
+	}
+	return visible
+}
+
+// displayName strips the first matching strip-label-prefix from the label
+// name, unless the stripped name would clash with another label.
+func (h *Handler) displayName(name string, labels map[string]string) string {
+	for _, prefix := range h.config.StripLabelPrefix {
+		if stripped, ok := strings.CutPrefix(name, prefix); ok && stripped != "" {
+			if _, clash := labels[stripped]; !clash {
+				return stripped
+			}
+			return name
+		}
+	}
+	return name
+}
+
+// labelsBlocks renders labels according to label-render:
+//
+//	json     - code block with the labels as a JSON object
+//	keyvalue - code block with a name=value line per label
+//	fields   - section fields with the label name in bold above its value
+func (h *Handler) labelsBlocks(labels map[string]string) []slack.Block {
+	if h.config.LabelRender != "fields" {
+		return []slack.Block{slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("```%s```", h.formatLabels(labels)), false, false), nil, nil)}
+	}
+	var fields []*slack.TextBlockObject
+	for _, pair := range sortedLabels(h.collapseNewlines(labels)) {
+		fields = append(fields, slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("*%s*\n%s", pair.Name, strings.ReplaceAll(pair.Value, "\n", `\n`)), false, false))
+	}
+	// a section holds at most 10 fields
+	var blocks []slack.Block
+	for _, chunk := range chunkBy(fields, 10) {
+		blocks = append(blocks, slack.NewSectionBlock(nil, chunk, nil))
+	}
+	return blocks
+}
+
+func (h *Handler) collapseNewlines(labels map[string]string) map[string]string {
+	if h.config.LabelNewlines != "collapse" {
+		return labels
+	}
+	collapsed := map[string]string{}
+	for name, value := range labels {
+		collapsed[name] = strings.Join(strings.Fields(value), " ")
+	}
+	return collapsed
+}
+
+// formatLabels renders labels as text of a code block, the fields mode falls
+// back to name=value lines in places where only text is possible.
+func (h *Handler) formatLabels(labels map[string]string) string {
+	labels = h.collapseNewlines(labels)
+	if h.config.LabelRender != "json" {
+		var lines []string
+		for _, pair := range sortedLabels(labels) {
+			lines = append(lines, pair.Name+"="+strings.ReplaceAll(pair.Value, "\n", `\n`))
+		}
+		return strings.Join(lines, "\n")
+	}
+	// json.Marshal orders map keys, so the labels render in the same order every time
+	labelsJson, err := json.Marshal(labels)
+	if err != nil {
+		slog.Error("failed to marshal labels", "err", err)
+		labelsJson = []byte{}
+	}
+	labelsStr := string(labelsJson)
+	return strings.ReplaceAll(strings.ReplaceAll(labelsStr, `":"`, `": "`), `","`, `", "`)
+}
+
+func (h *Handler) buildButtons(alert Alert, orgID int64) []slack.BlockElement {
+	var buttons []slack.BlockElement
+
+	generatorButton := slack.NewButtonBlockElement("generator", "", slack.NewTextBlockObject("plain_text", ":information_source: Details", true, false))
+	if h.config.GrafanaAlertSource {
+		generatorButton.URL = alert.GeneratorURL
+	} else {
+		generatorButton.URL = withOrgID(fmt.Sprintf("%s/alerting/list?queryString=%s&ruleType=alerting", h.config.GrafanaUrl, url.QueryEscape(labelsQuery(alert.Labels))), orgID)
+	}
+	generatorButton.Style = slack.StylePrimary
+	buttons = append(buttons, generatorButton)
+
+	if !h.config.GrafanaAlertSource {
+		expr, err := generatorExpr(alert.GeneratorURL)
+		if err != nil {
+			slog.Warn("cannot parse generator url", "url", alert.GeneratorURL, "err", err)
+		} else {
+			exploreButton := slack.NewButtonBlockElement("explore", "", slack.NewTextBlockObject("plain_text", ":chart_with_upwards_trend: Explore", true, false))
+			exploreButton.URL = withOrgID(h.exploreURL(alert, expr), orgID)
+			exploreButton.Style = slack.StylePrimary
+			buttons = append(buttons, exploreButton)
+		}
+	}
+
+	if alert.Status != "resolved" {
+		if runbookUrl, ok := alert.Annotations["runbook_url"]; ok && runbookUrl != "" {
+			runbookButton := slack.NewButtonBlockElement("runbook", "", slack.NewTextBlockObject("plain_text", ":page_with_curl: Runbook", true, false))
+			runbookButton.URL = runbookUrl
+			runbookButton.Style = slack.StyleDefault
+			buttons = append(buttons, runbookButton)
+		}
+	}
+
+	if alert.Status != "resolved" && !h.config.DisableGrafanaSilenceButton {
+		silenceButton := slack.NewButtonBlockElement("silence", "", slack.NewTextBlockObject("plain_text", ":no_bell: Silence", true, false))
+		if h.config.GrafanaAlertSource {
+			silenceButton.URL = alert.SilenceURL
+		} else {
+			var matchers []string
+			for _, pair := range sortedLabels(alert.Labels) {
+				// quoted values keep matchers with commas, quotes or spaces intact
+				matcher := fmt.Sprintf("%s=%s", pair.Name, strconv.Quote(pair.Value))
+				matchers = append(matchers, fmt.Sprintf(`matcher=%s`, url.QueryEscape(matcher)))
+			}
+			silenceButton.URL = withOrgID(fmt.Sprintf("%s/alerting/silence/new?alertmanager=%s&%s", h.config.GrafanaUrl, url.QueryEscape(h.config.AlertmanagerName), strings.Join(matchers, "&")), orgID)
+		}
+		silenceButton.Style = slack.StyleDanger
+		buttons = append(buttons, silenceButton)
+	}
+
+	if alert.Status != "resolved" && h.config.SnoozeAlertmanagerUrl != "" {
+		if snoozeButton := h.snoozeButton(alert); snoozeButton != nil {
+			buttons = append(buttons, snoozeButton)
+		}
+	}
+
+	return buttons
+}
+
+func (h *Handler) buildContext(alert Alert, orgID int64) []slack.MixedElement {
+	var contextElements []slack.MixedElement
+	if alert.ValueString != "" {
+		contextElements = append(contextElements, slack.NewTextBlockObject("plain_text", fmt.Sprintf("Value: %s", extractValue(alert.ValueString, h.config.ValuePrecision, h.config.ValueUnitStyle)), true, false))
+	}
+	contextElements = append(contextElements, slack.NewTextBlockObject("mrkdwn", h.formatTime("Started at", alert.StartsAt), false, false))
+	if !alert.EndsAt.IsZero() {
+		contextElements = append(contextElements, slack.NewTextBlockObject("mrkdwn", h.formatTime("Ended at", alert.EndsAt), false, false))
+	}
+	if h.config.FiringDuration && alert.Status == "resolved" && alert.EndsAt.After(alert.StartsAt) {
+		contextElements = append(contextElements, slack.NewTextBlockObject("plain_text", fmt.Sprintf("Was firing for %s", humanizeDuration(alert.EndsAt.Sub(alert.StartsAt))), true, false))
+	}
+	if h.config.FingerprintLink && h.config.GrafanaUrl != "" && alert.Fingerprint != "" {
+		contextElements = append(contextElements, slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("Fingerprint: <%s|%s>", withOrgID(h.fingerprintURL(alert), orgID), alert.Fingerprint), false, false))
+	}
+	if ruleUid := alert.Labels["__alert_rule_uid__"]; h.config.RuleLink && h.config.GrafanaUrl != "" && ruleUid != "" {
+		ruleUrl := withOrgID(fmt.Sprintf("%s/alerting/grafana/%s/view", h.config.GrafanaUrl, url.PathEscape(ruleUid)), orgID)
+		contextElements = append(contextElements, slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("Rule: <%s|%s>", ruleUrl, ruleUid), false, false))
+	}
+
+	return contextElements
+}
+
+// fingerprintURL points to the grafana alert groups view narrowed down to the
+// alert instance by its labels, which is what the fingerprint is derived from.
+func (h *Handler) fingerprintURL(alert Alert) string {
+	return fmt.Sprintf("%s/alerting/groups?alertmanager=%s&queryString=%s", h.config.GrafanaUrl, url.QueryEscape(h.config.AlertmanagerName), url.QueryEscape(labelsQuery(alert.Labels)))
+}
+
+// withOrgID scopes a link built from grafanaUrl to the org of the notification,
+// otherwise users of multi-org grafana land in their default org.
+func withOrgID(link string, orgID int64) string {
+	if orgID == 0 {
+		return link
+	}
+	separator := "?"
+	if strings.Contains(link, "?") {
+		separator = "&"
+	}
+	return fmt.Sprintf("%s%sorgId=%d", link, separator, orgID)
+}
+
+func labelsQuery(labels map[string]string) string {
+	var matchers []string
+	for _, pair := range sortedLabels(labels) {
+		matchers = append(matchers, fmt.Sprintf(`%s="%s"`, pair.Name, pair.Value))
+	}
+	return fmt.Sprintf("{%s}", strings.Join(matchers, ","))
+}
+
+func (h *Handler) formatTime(prefix string, t time.Time) string {
+	if h.config.DateFormat == "" {
+		return fmt.Sprintf("<!date^%d^%s: {date_num} {time_secs}|_>", t.Unix(), prefix)
+	}
+	return fmt.Sprintf("%s: %s", prefix, t.In(h.location).Format(h.config.DateFormat))
+}
+
+// generatorExpr extracts the query of a prometheus generator url. Some sources
+// send the url with its ampersands escaped once more, as \u0026 or &amp;, which
+// is undone before parsing.
+func generatorExpr(generatorURL string) (string, error) {
+	unescaped := strings.NewReplacer(`\u0026`, "&", "&amp;", "&").Replace(generatorURL)
+	parsed, err := url.ParseRequestURI(unescaped)
+	if err != nil {
+		return "", err
+	}
+	return parsed.Query().Get("g0.expr"), nil
+}
+
+func (h *Handler) exploreURL(alert Alert, expr string) string {
+	expr = strings.ReplaceAll(expr, `"`, `\"`)
+	uid := h.config.ExploreDatasourceUid
+	if labelUid, ok := alert.Labels["datasource_uid"]; ok && labelUid != "" {
+		uid = labelUid
+	}
+	if uid == "" {
+		expStr := fmt.Sprintf(`{"datasource":"%s","queries":[{"datasource":"%s","expr":"%s","refId":"A"}],"range":{"from":"now-1h","to":"now"}}`, h.config.ExploreDatasource, h.config.ExploreDatasource, expr)
+		return fmt.Sprintf("%s/explore?left=%s", h.config.GrafanaUrl, url.QueryEscape(expStr))
+	}
+	panes := fmt.Sprintf(`{"alert":{"datasource":"%s","queries":[{"refId":"A","expr":"%s","datasource":{"uid":"%s"}}],"range":{"from":"now-1h","to":"now"}}}`, uid, expr, uid)
+	return fmt.Sprintf("%s/explore?schemaVersion=1&panes=%s", h.config.GrafanaUrl, url.QueryEscape(panes))
+}
+
+func buildFooter(links FooterLinks, externalURL string, externalURLText string) slack.Block {
+	var texts []string
+	if externalURL != "" && externalURLText != "" {
+		texts = append(texts, fmt.Sprintf("<%s|%s>", externalURL, externalURLText))
+	}
+	for _, link := range links {
+		texts = append(texts, fmt.Sprintf("<%s|%s>", link.URL, link.Text))
+	}
+	if len(texts) == 0 {
+		return nil
+	}
+	return slack.NewContextBlock("footer", slack.NewTextBlockObject("mrkdwn", strings.Join(texts, " • "), false, false))
+}
+
 func groupByStatus(msg GrafanaMsg) map[string][]Alert {
 	grouped := map[string][]Alert{}
 	for _, alert := range msg.Alerts {
@@ -250,54 +1466,172 @@ func groupByStatus(msg GrafanaMsg) map[string][]Alert {
 	return grouped
 }
 
-func extractValue(valueString string) string {
+// groupByLabel groups alerts by the value of the label, alerts without it
+// share the group of the empty value.
+func groupByLabel(msg GrafanaMsg, label string) map[string][]Alert {
+	grouped := map[string][]Alert{}
+	for _, alert := range msg.Alerts {
+		value := alert.Labels[label]
+		grouped[value] = append(grouped[value], alert)
+	}
+	return grouped
+}
+
+// sortedGroups orders label groups alphabetically with the group of alerts
+// lacking the label last.
+func sortedGroups(grouped map[string][]Alert) []string {
+	groups := make([]string, 0, len(grouped))
+	for group := range grouped {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i] == "" || groups[j] == "" {
+			return groups[j] == ""
+		}
+		return groups[i] < groups[j]
+	})
+	return groups
+}
+
+// sortBySeverity orders alerts by the position of their severity label in
+// severity-order, alerts with unlisted severities keep their order at the end.
+func (h *Handler) sortBySeverity(alerts []Alert) []Alert {
+	if len(h.config.SeverityOrder) == 0 {
+		return alerts
+	}
+	rank := func(alert Alert) int {
+		if i := slices.Index(h.config.SeverityOrder, alert.Labels["severity"]); i >= 0 {
+			return i
+		}
+		return len(h.config.SeverityOrder)
+	}
+	sorted := slices.Clone(alerts)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return rank(sorted[i]) < rank(sorted[j])
+	})
+	return sorted
+}
+
+// sortedStatuses orders firing before resolved and any other status after
+// them alphabetically, so firing alerts are posted on top.
+func sortedStatuses(grouped map[string][]Alert) []string {
+	priority := map[string]int{"firing": 0, "resolved": 1}
+	statuses := make([]string, 0, len(grouped))
+	for status := range grouped {
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		pi, iok := priority[statuses[i]]
+		pj, jok := priority[statuses[j]]
+		if !iok {
+			pi = len(priority)
+		}
+		if !jok {
+			pj = len(priority)
+		}
+		if pi != pj {
+			return pi < pj
+		}
+		return statuses[i] < statuses[j]
+	})
+	return statuses
+}
+
+func extractValue(valueString string, precision int, unitStyle string) string {
 	// [ var='B' labels={job_name=XXX, namespace=yyy} value=123456 ]
 	parts := strings.Split(valueString, "value=")
 	if len(parts) != 2 {
-		log.Printf("cannot split value by 'value=': %s", valueString)
+		slog.Warn("cannot split value by 'value='", "value", valueString)
 		return valueString
 	}
 	value := strings.Split(parts[1], " ")
 	if len(value) == 0 {
-		log.Printf("cannot split value by ' ': %s", valueString)
+		slog.Warn("cannot split value by ' '", "value", valueString)
 		return valueString
 	}
-	str, err := humanize(value[0])
+	str, err := humanize(value[0], precision, unitStyle)
 	if err != nil {
-		log.Printf("cannot humanize value: %s", value[0])
+		slog.Warn("cannot humanize value", "value", value[0], "err", err)
 		return value[0]
 	}
 	return str
 }
 
-func humanize(i string) (string, error) {
+// humanize formats the value with the given number of significant digits and
+// a unit prefix: SI prefixes (k, M, m, u...) for the "si" style and binary
+// prefixes (Ki, Mi, Gi...) for the "binary" style, which are not applied to
+// values below 1.
+func humanize(i string, precision int, unitStyle string) (string, error) {
 	v, err := strconv.ParseFloat(i, 64)
 	if err != nil {
 		return "", err
 	}
-	if v == 0 || math.IsNaN(v) || math.IsInf(v, 0) {
-		return fmt.Sprintf("%.4g", v), nil
+	if v == 0 {
+		return "0", nil
 	}
-	if math.Abs(v) >= 1 {
-		prefix := ""
-		for _, p := range []string{"k", "M", "G", "T", "P", "E", "Z", "Y"} {
-			if math.Abs(v) < 1000 {
-				break
-			}
-			prefix = p
-			v /= 1000
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return fmt.Sprintf("%.*g", precision, v), nil
+	}
+	rounded := math.Abs(roundSignificant(v, precision))
+	if unitStyle == "binary" {
+		if rounded < 1 || rounded >= math.Pow(1024, 9) {
+			return fmt.Sprintf("%.*g", precision, v), nil
 		}
-		return fmt.Sprintf("%.4g%s", v, prefix), nil
+		return scaleUnit(v, precision, 1024, []string{"Ki", "Mi", "Gi", "Ti", "Pi", "Ei", "Zi", "Yi"}), nil
+	}
+	// values beyond the Y and y prefixes, like 1e30 or 1e-30, are kept in scientific notation
+	if rounded >= 1e27 || rounded < 1e-24 {
+		return fmt.Sprintf("%.*g", precision, v), nil
+	}
+	if rounded >= 1 {
+		return scaleUnit(v, precision, 1000, []string{"k", "M", "G", "T", "P", "E", "Z", "Y"}), nil
 	}
 	prefix := ""
 	for _, p := range []string{"m", "u", "n", "p", "f", "a", "z", "y"} {
-		if math.Abs(v) >= 1 {
+		if math.Abs(roundSignificant(v, precision)) >= 1 {
 			break
 		}
 		prefix = p
 		v *= 1000
 	}
-	return fmt.Sprintf("%.4g%s", v, prefix), nil
+	return fmt.Sprintf("%.*g%s", precision, v, prefix), nil
+}
+
+// scaleUnit divides v by base while it is not below base, comparing the value
+// rounded to the precision, so e.g. 999.95 is promoted to 1k instead of 1000.
+func scaleUnit(v float64, precision int, base float64, prefixes []string) string {
+	prefix := ""
+	for _, p := range prefixes {
+		if math.Abs(roundSignificant(v, precision)) < base {
+			break
+		}
+		prefix = p
+		v /= base
+	}
+	return fmt.Sprintf("%.*g%s", precision, v, prefix)
+}
+
+func roundSignificant(v float64, precision int) float64 {
+	rounded, _ := strconv.ParseFloat(strconv.FormatFloat(v, 'g', precision, 64), 64)
+	return rounded
+}
+
+// humanizeDuration renders the two most significant units, e.g. 2d 3h or 5m 10s.
+func humanizeDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	days := int(d / (24 * time.Hour))
+	hours := int(d % (24 * time.Hour) / time.Hour)
+	minutes := int(d % time.Hour / time.Minute)
+	seconds := int(d % time.Minute / time.Second)
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd %dh", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	case minutes > 0:
+		return fmt.Sprintf("%dm %ds", minutes, seconds)
+	}
+	return fmt.Sprintf("%ds", seconds)
 }
 
 func chunkBy[T any](items []T, chunkSize int) (chunks [][]T) {
@@ -307,13 +1641,28 @@ func chunkBy[T any](items []T, chunkSize int) (chunks [][]T) {
 	return append(chunks, items)
 }
 
-func hash(items map[string]string) string {
-	var text string
-	for k, v := range items {
-		text = text + k + v
+type labelPair struct {
+	Name, Value string
+}
+
+// sortedLabels returns the labels ordered by name, so everything derived from
+// them is the same for every notification of an alert.
+func sortedLabels(labels map[string]string) []labelPair {
+	pairs := make([]labelPair, 0, len(labels))
+	for name, value := range labels {
+		pairs = append(pairs, labelPair{Name: name, Value: value})
 	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Name < pairs[j].Name
+	})
+	return pairs
+}
+
+func hash(items map[string]string) string {
 	algorithm := fnv.New32a()
-	_, _ = algorithm.Write([]byte(text))
+	for _, pair := range sortedLabels(items) {
+		_, _ = algorithm.Write([]byte(pair.Name + pair.Value))
+	}
 	return strconv.FormatUint(uint64(algorithm.Sum32()), 10)
 }
 
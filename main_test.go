@@ -0,0 +1,1607 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+var update = flag.Bool("update", false, "Regenerate the golden files in testdata")
+
+func testAlert(status string, name string) Alert {
+	return Alert{
+		Status:      status,
+		Labels:      map[string]string{"alertname": name},
+		Annotations: map[string]string{"summary": name},
+		StartsAt:    time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+}
+
+func testAlerts(status string, count int) []Alert {
+	var alerts []Alert
+	for i := 0; i < count; i++ {
+		alerts = append(alerts, testAlert(status, fmt.Sprintf("%s-%d", status, i)))
+	}
+	return alerts
+}
+
+func previewTexts(messages []SlackMsg) []string {
+	var texts []string
+	for _, msg := range messages {
+		texts = append(texts, msg.Text)
+	}
+	return texts
+}
+
+func buttonURLs(buttons []slack.BlockElement) map[string]string {
+	urls := map[string]string{}
+	for _, element := range buttons {
+		button := element.(*slack.ButtonBlockElement)
+		urls[button.ActionID] = button.URL
+	}
+	return urls
+}
+
+// fakeWebhook records the messages posted to a slack incoming webhook.
+type fakeWebhook struct {
+	*httptest.Server
+	mu       sync.Mutex
+	messages []SlackMsg
+}
+
+func newFakeWebhook(t *testing.T) *fakeWebhook {
+	f := &fakeWebhook{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg SlackMsg
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Errorf("webhook received invalid message: %v", err)
+		}
+		f.mu.Lock()
+		f.messages = append(f.messages, msg)
+		f.mu.Unlock()
+		_, _ = w.Write([]byte("ok"))
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeWebhook) posted() []SlackMsg {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.messages)
+}
+
+// testConfig mirrors the flag defaults, so tests and golden files show what a
+// plain deployment posts. Messages are posted one at a time to keep their order.
+func testConfig() Config {
+	return Config{
+		ConfigWatchInterval:         30 * time.Second,
+		LogFormat:                   "text",
+		LogLevel:                    "info",
+		ListenAddress:               ":8080",
+		UpdateResolved:              true,
+		Username:                    "Grafana",
+		DefaultChannel:              "alerts",
+		ChannelPrecedence:           StringList{"query", "label", "route", "severity", "org"},
+		StatusPolicy:                "alert",
+		GrafanaAlertSource:          true,
+		DisableGrafanaSilenceButton: true,
+		AlertmanagerName:            "Alertmanager",
+		ExploreDatasource:           "prometheus",
+		ExternalURLText:             "Open Grafana",
+		TeamLabelKey:                "label_app_kubernetes_io_team",
+		LabelRender:                 "json",
+		LabelNewlines:               "escape",
+		ValuePrecision:              4,
+		ValueUnitStyle:              "si",
+		Timezone:                    "UTC",
+		LayoutLabel:                 "kind",
+		SnoozeDuration:              time.Hour,
+		MaxConcurrentWait:           5 * time.Second,
+		WorkspaceBackoff:            true,
+		PostConcurrency:             1,
+		ReadinessInterval:           time.Minute,
+		ReadTimeout:                 5 * time.Second,
+		WriteTimeout:                30 * time.Second,
+		IdleTimeout:                 120 * time.Second,
+		ShutdownDelay:               5 * time.Second,
+		MaxBodyBytes:                4 << 20,
+		SlackTimeout:                10 * time.Second,
+		HmacHeader:                  "X-Grafana-Signature",
+	}
+}
+
+// webhookHandler returns a handler posting to a fake webhook.
+func webhookHandler(t *testing.T, config Config) (*Handler, *fakeWebhook) {
+	webhook := newFakeWebhook(t)
+	config.WebhookUrl = webhook.URL
+	return newHandler(config), webhook
+}
+
+// notify sends the grafana notification to the webhook endpoint of h.
+func notify(t *testing.T, h *Handler, target string, msg GrafanaMsg) *httptest.ResponseRecorder {
+	t.Helper()
+	body, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	h.handleWebhookRequest(w, httptest.NewRequest(http.MethodPost, target, bytes.NewReader(body)))
+	return w
+}
+
+func TestBuildMessagesGroupsByStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		alerts []Alert
+		want   []string
+	}{
+		{
+			name:   "firing only",
+			alerts: []Alert{testAlert("firing", "a"), testAlert("firing", "b")},
+			want:   []string{"Fired: [a] [b] "},
+		},
+		{
+			name:   "resolved only",
+			alerts: []Alert{testAlert("resolved", "a"), testAlert("resolved", "b")},
+			want:   []string{"Resolved: [a] [b] "},
+		},
+		{
+			name:   "mixed",
+			alerts: []Alert{testAlert("firing", "a"), testAlert("resolved", "b"), testAlert("firing", "c")},
+			want:   []string{"Fired: [a] [c] ", "Resolved: [b] "},
+		},
+	}
+	h := newHandler(testConfig())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages := h.buildMessages(GrafanaMsg{Alerts: tt.alerts}, "alerts")
+			if got := previewTexts(messages); !slices.Equal(got, tt.want) {
+				t.Errorf("preview texts = %q, want %q", got, tt.want)
+			}
+			for _, msg := range messages {
+				for _, alert := range msg.Alerts {
+					if alert.Status != msg.Alerts[0].Status {
+						t.Errorf("message %q mixes %s and %s alerts", msg.Text, msg.Alerts[0].Status, alert.Status)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestBuildMessagesOrdersStatuses(t *testing.T) {
+	tests := []struct {
+		name   string
+		alerts []Alert
+		want   []string
+	}{
+		{
+			name:   "resolved first in payload",
+			alerts: []Alert{testAlert("resolved", "b"), testAlert("firing", "a")},
+			want:   []string{"Fired: [a] ", "Resolved: [b] "},
+		},
+		{
+			// unknown statuses count as firing in the preview but come last
+			name:   "unknown statuses last",
+			alerts: []Alert{testAlert("unknown", "d"), testAlert("pending", "c"), testAlert("resolved", "b"), testAlert("firing", "a")},
+			want:   []string{"Fired: [a] ", "Resolved: [b] ", "Fired: [c] ", "Fired: [d] "},
+		},
+	}
+	h := newHandler(testConfig())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// map iteration order varies, so a lucky order must not hide a regression
+			for i := 0; i < 20; i++ {
+				got := previewTexts(h.buildMessages(GrafanaMsg{Alerts: tt.alerts}, "alerts"))
+				if !slices.Equal(got, tt.want) {
+					t.Fatalf("preview texts = %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPostAllPostsFiringBeforeResolved(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		posted []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg SlackMsg
+		_ = json.NewDecoder(r.Body).Decode(&msg)
+		if strings.HasPrefix(msg.Text, "Fired") {
+			// a slow firing post must not let the resolved one overtake it
+			time.Sleep(50 * time.Millisecond)
+		}
+		mu.Lock()
+		posted = append(posted, msg.Text)
+		mu.Unlock()
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	config := testConfig()
+	config.PostConcurrency = 4
+	h := newHandler(config)
+	alerts := append(testAlerts("firing", 8), testAlert("resolved", "r"))
+	msgs := h.buildMessages(GrafanaMsg{Alerts: alerts}, "alerts")
+	for i := range msgs {
+		msgs[i].WebhookUrl = server.URL
+	}
+	if err := h.postAll(context.Background(), GrafanaMsg{}, msgs, "alerts"); err != nil {
+		t.Fatal(err)
+	}
+	if len(posted) != 3 || posted[2] != "Resolved: [r] " {
+		t.Errorf("posted = %q, want both firing chunks before the resolved message", posted)
+	}
+}
+
+func TestBuildButtons(t *testing.T) {
+	alert := Alert{
+		Status:       "firing",
+		Labels:       map[string]string{"alertname": "HighLoad", "instance": "host:9100"},
+		GeneratorURL: "http://prometheus:9090/graph?g0.expr=up+%3D%3D+0&g0.tab=1",
+		SilenceURL:   "https://grafana.example.com/alerting/silence/new?alertmanager=grafana&matcher=alertname%3DHighLoad",
+	}
+	explore := `{"datasource":"prometheus","queries":[{"datasource":"prometheus","expr":"up == 0","refId":"A"}],"range":{"from":"now-1h","to":"now"}}`
+	withSilence := testConfig()
+	withSilence.DisableGrafanaSilenceButton = false
+	external := withSilence
+	external.GrafanaAlertSource = false
+	external.GrafanaUrl = "https://grafana.example.com"
+	tests := []struct {
+		name   string
+		config Config
+		want   map[string]string
+	}{
+		{
+			name:   "grafana alert source",
+			config: withSilence,
+			want: map[string]string{
+				"generator": alert.GeneratorURL,
+				"silence":   alert.SilenceURL,
+			},
+		},
+		{
+			name:   "grafana alert source without silence button",
+			config: testConfig(),
+			want: map[string]string{
+				"generator": alert.GeneratorURL,
+			},
+		},
+		{
+			name:   "external alertmanager",
+			config: external,
+			want: map[string]string{
+				"generator": "https://grafana.example.com/alerting/list?queryString=%7Balertname%3D%22HighLoad%22%2Cinstance%3D%22host%3A9100%22%7D&ruleType=alerting",
+				"explore":   "https://grafana.example.com/explore?left=" + url.QueryEscape(explore),
+				"silence":   "https://grafana.example.com/alerting/silence/new?alertmanager=Alertmanager&matcher=alertname%3D%22HighLoad%22&matcher=instance%3D%22host%3A9100%22",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := buttonURLs(newHandler(tt.config).buildButtons(alert, 0))
+			if len(got) != len(tt.want) {
+				t.Errorf("buttons = %v, want %v", got, tt.want)
+			}
+			for id, want := range tt.want {
+				if got[id] != want {
+					t.Errorf("%s button url = %q, want %q", id, got[id], want)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildMessagesChunksAlerts(t *testing.T) {
+	tests := []struct {
+		alerts int
+		want   []int
+	}{
+		{alerts: 1, want: []int{1}},
+		{alerts: 7, want: []int{7}},
+		{alerts: 8, want: []int{7, 1}},
+		{alerts: 14, want: []int{7, 7}},
+		{alerts: 15, want: []int{7, 7, 1}},
+	}
+	h := newHandler(testConfig())
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d alerts", tt.alerts), func(t *testing.T) {
+			var got []int
+			for _, msg := range h.buildMessages(GrafanaMsg{Alerts: testAlerts("firing", tt.alerts)}, "alerts") {
+				got = append(got, len(msg.Alerts))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("alerts per message = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractValue(t *testing.T) {
+	tests := []struct {
+		name        string
+		valueString string
+		unitStyle   string
+		want        string
+	}{
+		{"si prefix", "[ var='B' labels={job_name=XXX, namespace=yyy} value=123456 ]", "si", "123.5k"},
+		{"binary prefix", "[ var='B' labels={} value=1048576 ]", "binary", "1Mi"},
+		{"zero", "[ var='B' labels={} value=0 ]", "si", "0"},
+		{"no value", "[ var='B' labels={} ]", "si", "[ var='B' labels={} ]"},
+		{"several values", "[ var='A' labels={} value=1 ], [ var='B' labels={} value=2 ]", "si", "[ var='A' labels={} value=1 ], [ var='B' labels={} value=2 ]"},
+		{"not a number", "[ var='B' labels={} value=abc ]", "si", "abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractValue(tt.valueString, 4, tt.unitStyle); got != tt.want {
+				t.Errorf("extractValue(%q) = %q, want %q", tt.valueString, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHumanize(t *testing.T) {
+	tests := []struct {
+		value     string
+		unitStyle string
+		want      string
+		wantErr   bool
+	}{
+		{value: "0", unitStyle: "si", want: "0"},
+		{value: "1", unitStyle: "si", want: "1"},
+		{value: "123456", unitStyle: "si", want: "123.5k"},
+		{value: "-2500", unitStyle: "si", want: "-2.5k"},
+		{value: "0.5", unitStyle: "si", want: "500m"},
+		{value: "1536", unitStyle: "binary", want: "1.5Ki"},
+		{value: "0.5", unitStyle: "binary", want: "0.5"},
+		{value: "NaN", unitStyle: "si", want: "NaN"},
+		{value: "999.95", unitStyle: "si", want: "1k"},
+		{value: "999.94", unitStyle: "si", want: "999.9"},
+		{value: "0.0009999", unitStyle: "si", want: "999.9u"},
+		{value: "0.00099995", unitStyle: "si", want: "1m"},
+		{value: "-999.95", unitStyle: "si", want: "-1k"},
+		{value: "-0.0009999", unitStyle: "si", want: "-999.9u"},
+		{value: "-0.5", unitStyle: "si", want: "-500m"},
+		{value: "1000", unitStyle: "si", want: "1k"},
+		{value: "1000000", unitStyle: "si", want: "1M"},
+		{value: "0.001", unitStyle: "si", want: "1m"},
+		{value: "1e-6", unitStyle: "si", want: "1u"},
+		{value: "-1000000000", unitStyle: "si", want: "-1G"},
+		{value: "1024", unitStyle: "binary", want: "1Ki"},
+		{value: "1048576", unitStyle: "binary", want: "1Mi"},
+		{value: "-0", unitStyle: "si", want: "0"},
+		{value: "1.23e6", unitStyle: "si", want: "1.23M"},
+		{value: "4.5e-9", unitStyle: "si", want: "4.5n"},
+		{value: "-1.23E6", unitStyle: "si", want: "-1.23M"},
+		{value: "1e30", unitStyle: "si", want: "1e+30"},
+		{value: "1e-30", unitStyle: "si", want: "1e-30"},
+		{value: "1e400", unitStyle: "si", wantErr: true},
+		{value: "1e30", unitStyle: "binary", want: "1e+30"},
+		{value: "abc", unitStyle: "si", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.unitStyle+" "+tt.value, func(t *testing.T) {
+			got, err := humanize(tt.value, 4, tt.unitStyle)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("humanize(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("humanize(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildMessagesGolden(t *testing.T) {
+	externalConfig := testConfig()
+	externalConfig.GrafanaAlertSource = false
+	externalConfig.GrafanaUrl = "https://grafana.example.com"
+	externalConfig.DisableGrafanaSilenceButton = false
+	externalConfig.ExternalURLText = ":link: Open Alertmanager"
+	compactConfig := testConfig()
+	compactConfig.Compact = true
+
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{name: "firing-only", config: testConfig()},
+		{name: "resolved-only", config: testConfig()},
+		{name: "mixed", config: testConfig()},
+		{name: "multi-chunk", config: testConfig()},
+		{name: "missing-annotations", config: testConfig()},
+		{name: "external-alertmanager", config: externalConfig},
+		{name: "compact", config: compactConfig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input, err := os.ReadFile(filepath.Join("testdata", tt.name+".json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var msg GrafanaMsg
+			if err := json.Unmarshal(input, &msg); err != nil {
+				t.Fatal(err)
+			}
+			got, err := json.MarshalIndent(newHandler(tt.config).buildMessages(msg, "alerts"), "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+
+			golden := filepath.Join("testdata", tt.name+".golden.json")
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("messages differ from %s, run go test -update to regenerate it:\n%s", golden, got)
+			}
+		})
+	}
+}
+
+func TestLoggingRoundTripperHidesWebhookPath(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	client := &http.Client{Transport: LoggingRoundTripper{Proxied: http.DefaultTransport}}
+	res, err := client.Post(srv.URL+"/services/T000/B000/secret", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if strings.Contains(logs.String(), "secret") {
+		t.Errorf("log leaks the webhook path: %s", logs.String())
+	}
+	if !strings.Contains(logs.String(), strings.TrimPrefix(srv.URL, "http://")) {
+		t.Errorf("log misses the host: %s", logs.String())
+	}
+}
+
+func TestPostWebhookRateLimited(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		retryAfter string
+		want       time.Duration
+	}{
+		{name: "retry after", retryAfter: "30", want: 30 * time.Second},
+		{name: "missing", retryAfter: "", want: defaultRetryAfter},
+		{name: "zero", retryAfter: "0", want: defaultRetryAfter},
+		{name: "negative", retryAfter: "-1", want: defaultRetryAfter},
+		{name: "http date", retryAfter: "Wed, 21 Oct 2015 07:28:00 GMT", want: defaultRetryAfter},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(http.StatusTooManyRequests)
+			}))
+			defer srv.Close()
+
+			err := postWebhook(context.Background(), srv.URL, &SlackMsg{})
+			var rateLimited *slack.RateLimitedError
+			if !errors.As(err, &rateLimited) {
+				t.Fatalf("err = %v, want a rate limited error", err)
+			}
+			if rateLimited.RetryAfter != tt.want {
+				t.Errorf("retry after = %v, want %v", rateLimited.RetryAfter, tt.want)
+			}
+		})
+	}
+}
+
+// blocksJSON renders the blocks of the message for substring assertions.
+func blocksJSON(t *testing.T, msg SlackMsg) string {
+	t.Helper()
+	raw, err := json.Marshal(msg.Blocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(raw)
+}
+
+func TestBuildMessageFooterLinks(t *testing.T) {
+	tests := []struct {
+		name        string
+		links       FooterLinks
+		externalURL string
+		want        string
+	}{
+		{
+			name:  "footer links",
+			links: FooterLinks{{Text: "Wiki", URL: "https://wiki.example.com"}, {Text: "On-call", URL: "https://oncall.example.com"}},
+			want:  "<https://wiki.example.com|Wiki> • <https://oncall.example.com|On-call>",
+		},
+		{
+			name:        "external url first",
+			links:       FooterLinks{{Text: "Wiki", URL: "https://wiki.example.com"}},
+			externalURL: "https://grafana.example.com",
+			want:        "<https://grafana.example.com|Open Grafana> • <https://wiki.example.com|Wiki>",
+		},
+		{
+			name: "no footer",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			config.FooterLinks = tt.links
+			h := newHandler(config)
+			msg := h.buildMessage(GrafanaMsg{ExternalURL: tt.externalURL}, []Alert{testAlert("firing", "a")}, "alerts")
+			var footer *slack.ContextBlock
+			for _, block := range msg.Blocks.BlockSet {
+				if contextBlock, ok := block.(*slack.ContextBlock); ok && contextBlock.BlockID == "footer" {
+					footer = contextBlock
+				}
+			}
+			if tt.want == "" {
+				if footer != nil {
+					t.Errorf("footer = %+v, want none", footer)
+				}
+				return
+			}
+			if footer == nil {
+				t.Fatal("message has no footer")
+			}
+			if got := footer.ContextElements.Elements[0].(*slack.TextBlockObject).Text; got != tt.want {
+				t.Errorf("footer = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExploreURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		uid    string
+		labels map[string]string
+		want   string
+	}{
+		{
+			name: "datasource name",
+			want: "https://grafana.example.com/explore?left=" + url.QueryEscape(`{"datasource":"prometheus","queries":[{"datasource":"prometheus","expr":"up{job=\"node\"} == 0","refId":"A"}],"range":{"from":"now-1h","to":"now"}}`),
+		},
+		{
+			name: "datasource uid",
+			uid:  "P1809F7CD0C75ACF3",
+			want: "https://grafana.example.com/explore?schemaVersion=1&panes=" + url.QueryEscape(`{"alert":{"datasource":"P1809F7CD0C75ACF3","queries":[{"refId":"A","expr":"up{job=\"node\"} == 0","datasource":{"uid":"P1809F7CD0C75ACF3"}}],"range":{"from":"now-1h","to":"now"}}}`),
+		},
+		{
+			name:   "datasource uid label",
+			uid:    "P1809F7CD0C75ACF3",
+			labels: map[string]string{"datasource_uid": "abc123"},
+			want:   "https://grafana.example.com/explore?schemaVersion=1&panes=" + url.QueryEscape(`{"alert":{"datasource":"abc123","queries":[{"refId":"A","expr":"up{job=\"node\"} == 0","datasource":{"uid":"abc123"}}],"range":{"from":"now-1h","to":"now"}}}`),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			config.GrafanaUrl = "https://grafana.example.com"
+			config.ExploreDatasourceUid = tt.uid
+			h := newHandler(config)
+			if got := h.exploreURL(Alert{Labels: tt.labels}, `up{job="node"} == 0`); got != tt.want {
+				t.Errorf("explore url = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func contextTexts(elements []slack.MixedElement) []string {
+	var texts []string
+	for _, element := range elements {
+		texts = append(texts, element.(*slack.TextBlockObject).Text)
+	}
+	return texts
+}
+
+func TestBuildContextFingerprintLink(t *testing.T) {
+	alert := testAlert("firing", "HighLoad")
+	link := "Fingerprint: <https://grafana.example.com/alerting/groups?alertmanager=grafana&queryString=%7Balertname%3D%22HighLoad%22%7D|a1b2c3>"
+	disabled := testConfig()
+	disabled.GrafanaUrl = "https://grafana.example.com"
+	disabled.AlertmanagerName = "grafana"
+	linked := disabled
+	linked.FingerprintLink = true
+	withoutURL := linked
+	withoutURL.GrafanaUrl = ""
+	tests := []struct {
+		name        string
+		config      Config
+		fingerprint string
+		want        string
+	}{
+		{
+			name:        "link",
+			config:      linked,
+			fingerprint: "a1b2c3",
+			want:        link,
+		},
+		{
+			name:        "disabled",
+			config:      disabled,
+			fingerprint: "a1b2c3",
+		},
+		{
+			name:        "without grafana url",
+			config:      withoutURL,
+			fingerprint: "a1b2c3",
+		},
+		{
+			name:   "without fingerprint",
+			config: linked,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alert := alert
+			alert.Fingerprint = tt.fingerprint
+			var got string
+			for _, text := range contextTexts(newHandler(tt.config).buildContext(alert, 0)) {
+				if strings.HasPrefix(text, "Fingerprint:") {
+					got = text
+				}
+			}
+			if got != tt.want {
+				t.Errorf("fingerprint = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatLabelsMultiLineValue(t *testing.T) {
+	labels := map[string]string{"message": "line one\n  line two\n"}
+	tests := []struct {
+		render   string
+		newlines string
+		want     string
+	}{
+		{render: "json", newlines: "escape", want: `{"message": "line one\n  line two\n"}`},
+		{render: "json", newlines: "collapse", want: `{"message": "line one line two"}`},
+		{render: "keyvalue", newlines: "escape", want: `message=line one\n  line two\n`},
+		{render: "keyvalue", newlines: "collapse", want: `message=line one line two`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.render+" "+tt.newlines, func(t *testing.T) {
+			config := testConfig()
+			config.LabelRender = tt.render
+			config.LabelNewlines = tt.newlines
+			h := newHandler(config)
+			if got := h.formatLabels(labels); got != tt.want {
+				t.Errorf("labels = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLayoutPerKindLabel(t *testing.T) {
+	config := testConfig()
+	config.Layouts = StringMap{"infra": layoutCompact, "app": layoutFull}
+	config.ChannelLayouts = StringMap{"#compact": layoutCompact}
+	config.LabelRender = "keyvalue"
+	h := newHandler(config)
+	kindAlert := func(name string, kind string) Alert {
+		alert := testAlert("firing", name)
+		if kind != "" {
+			alert.Labels["kind"] = kind
+		}
+		return alert
+	}
+	tests := []struct {
+		name    string
+		alert   Alert
+		channel string
+		want    string
+	}{
+		{name: "infra", alert: kindAlert("a", "infra"), channel: "alerts", want: layoutCompact},
+		{name: "app", alert: kindAlert("b", "app"), channel: "#compact", want: layoutFull},
+		{name: "unknown kind falls back to channel", alert: kindAlert("c", "batch"), channel: "#compact", want: layoutCompact},
+		{name: "without kind", alert: kindAlert("d", ""), channel: "alerts", want: layoutFull},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := h.layoutFor(tt.alert, tt.channel); got != tt.want {
+				t.Errorf("layout = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	blocks := blocksJSON(t, h.buildMessage(GrafanaMsg{}, []Alert{kindAlert("a", "infra"), kindAlert("b", "app")}, "alerts"))
+	if strings.Contains(blocks, "kind=infra") {
+		t.Errorf("infra alert renders its labels: %s", blocks)
+	}
+	if !strings.Contains(blocks, "kind=app") {
+		t.Errorf("app alert misses its labels: %s", blocks)
+	}
+}
+
+func TestBuildMessagesPerChannelLayout(t *testing.T) {
+	config := testConfig()
+	config.ChannelPrecedence = StringList{"query"}
+	config.ChannelLayouts = StringMap{"#ops": layoutCompact, "#dev": layoutFull}
+	config.LabelRender = "keyvalue"
+	h, webhook := webhookHandler(t, config)
+	msg := GrafanaMsg{Alerts: []Alert{testAlert("firing", "a")}}
+	for _, channel := range []string{"%23ops", "%23dev"} {
+		if w := notify(t, h, "/?channel="+channel, msg); w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", w.Code)
+		}
+	}
+
+	posted := webhook.posted()
+	if len(posted) != 2 {
+		t.Fatalf("posted %d messages, want 2", len(posted))
+	}
+	for _, tt := range []struct {
+		msg        SlackMsg
+		channel    string
+		wantLabels bool
+	}{
+		{msg: posted[0], channel: "#ops", wantLabels: false},
+		{msg: posted[1], channel: "#dev", wantLabels: true},
+	} {
+		if tt.msg.Channel != tt.channel {
+			t.Errorf("channel = %s, want %s", tt.msg.Channel, tt.channel)
+		}
+		if got := strings.Contains(blocksJSON(t, tt.msg), "alertname=a"); got != tt.wantLabels {
+			t.Errorf("%s renders labels %v, want %v", tt.channel, got, tt.wantLabels)
+		}
+	}
+}
+
+func TestStatusPolicy(t *testing.T) {
+	tests := []struct {
+		policy string
+		want   []string
+	}{
+		{policy: "alert", want: []string{"Fired: [a] ", "Resolved: [b] "}},
+		{policy: "group", want: []string{"Fired: [a] [b] "}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			config := testConfig()
+			config.StatusPolicy = tt.policy
+			h, webhook := webhookHandler(t, config)
+			msg := GrafanaMsg{Status: "firing", Alerts: []Alert{testAlert("firing", "a"), testAlert("resolved", "b")}}
+			if w := notify(t, h, "/", msg); w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", w.Code)
+			}
+			if got := previewTexts(webhook.posted()); !slices.Equal(got, tt.want) {
+				t.Errorf("preview texts = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVisibleLabelsStripPrefix(t *testing.T) {
+	config := testConfig()
+	config.StripLabelPrefix = StringList{"label_app_kubernetes_io_"}
+	config.LabelRender = "keyvalue"
+	// the generator button is only rendered for external alertmanagers
+	config.GrafanaAlertSource = false
+	h := newHandler(config)
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   map[string]string
+	}{
+		{
+			name:   "stripped",
+			labels: map[string]string{"label_app_kubernetes_io_component": "api", "namespace": "prod"},
+			want:   map[string]string{"component": "api", "namespace": "prod"},
+		},
+		{
+			name:   "clash keeps the original",
+			labels: map[string]string{"label_app_kubernetes_io_component": "api", "component": "web"},
+			want:   map[string]string{"label_app_kubernetes_io_component": "api", "component": "web"},
+		},
+		{
+			name:   "prefix only",
+			labels: map[string]string{"label_app_kubernetes_io_": "x"},
+			want:   map[string]string{"label_app_kubernetes_io_": "x"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := h.visibleLabels(tt.labels)
+			if len(got) != len(tt.want) {
+				t.Errorf("labels = %v, want %v", got, tt.want)
+			}
+			for name, value := range tt.want {
+				if got[name] != value {
+					t.Errorf("label %s = %q, want %q", name, got[name], value)
+				}
+			}
+		})
+	}
+
+	// matchers keep using the original names
+	alert := testAlert("firing", "a")
+	alert.Labels["label_app_kubernetes_io_component"] = "api"
+	msg := h.buildMessage(GrafanaMsg{}, []Alert{alert}, "alerts")
+	blocks := blocksJSON(t, msg)
+	if !strings.Contains(blocks, "component=api") || strings.Contains(blocks, "label_app_kubernetes_io_component=api") {
+		t.Errorf("labels block does not strip the prefix: %s", blocks)
+	}
+	if generator := buttonURLs(h.buildButtons(alert, 0))["generator"]; !strings.Contains(generator, "label_app_kubernetes_io_component") {
+		t.Errorf("generator url = %s, want the original label names", generator)
+	}
+}
+
+func TestUsernameFromReceiver(t *testing.T) {
+	tests := []struct {
+		name         string
+		fromReceiver bool
+		receiver     string
+		want         string
+	}{
+		{name: "receiver", fromReceiver: true, receiver: "team-db", want: "team-db"},
+		{name: "without receiver", fromReceiver: true, want: "Grafana"},
+		{name: "disabled", receiver: "team-db", want: "Grafana"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			config.UsernameFromReceiver = tt.fromReceiver
+			h, webhook := webhookHandler(t, config)
+			notify(t, h, "/", GrafanaMsg{Receiver: tt.receiver, Alerts: []Alert{testAlert("firing", "a")}})
+			posted := webhook.posted()
+			if len(posted) != 1 || posted[0].Username != tt.want {
+				t.Errorf("posted %+v, want one message with username %s", posted, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildMessageAnnotationImage(t *testing.T) {
+	tests := []struct {
+		name            string
+		imageAnnotation string
+		annotations     map[string]string
+		want            string
+	}{
+		{
+			name:            "annotation image",
+			imageAnnotation: "image_url",
+			annotations:     map[string]string{"summary": "a", "image_url": "https://charts.example.com/a.png"},
+			want:            "https://charts.example.com/a.png",
+		},
+		{
+			name:        "not configured",
+			annotations: map[string]string{"summary": "a", "image_url": "https://charts.example.com/a.png"},
+		},
+		{
+			name:            "missing annotation",
+			imageAnnotation: "image_url",
+			annotations:     map[string]string{"summary": "a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			config.ImageAnnotation = tt.imageAnnotation
+			h := newHandler(config)
+			alert := testAlert("firing", "a")
+			alert.Annotations = tt.annotations
+			var images []*slack.ImageBlock
+			for _, block := range h.buildMessage(GrafanaMsg{}, []Alert{alert}, "alerts").Blocks.BlockSet {
+				if image, ok := block.(*slack.ImageBlock); ok {
+					images = append(images, image)
+				}
+			}
+			if tt.want == "" {
+				if len(images) != 0 {
+					t.Errorf("image blocks = %d, want none", len(images))
+				}
+				return
+			}
+			if len(images) != 1 || images[0].ImageURL != tt.want || images[0].AltText != "a" {
+				t.Errorf("image blocks = %+v, want one of %s", images, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildContextRuleLink(t *testing.T) {
+	alert := testAlert("firing", "a")
+	alert.Labels["__alert_rule_uid__"] = "fe1x2y"
+	disabled := testConfig()
+	disabled.GrafanaUrl = "https://grafana.example.com"
+	linked := disabled
+	linked.RuleLink = true
+	withoutURL := testConfig()
+	withoutURL.RuleLink = true
+	tests := []struct {
+		name   string
+		config Config
+		orgID  int64
+		want   string
+	}{
+		{
+			name:   "rule link",
+			config: linked,
+			want:   "Rule: <https://grafana.example.com/alerting/grafana/fe1x2y/view|fe1x2y>",
+		},
+		{
+			name:   "rule link of org",
+			config: linked,
+			orgID:  3,
+			want:   "Rule: <https://grafana.example.com/alerting/grafana/fe1x2y/view?orgId=3|fe1x2y>",
+		},
+		{
+			name:   "disabled",
+			config: disabled,
+		},
+		{
+			name:   "without grafana url",
+			config: withoutURL,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			for _, text := range contextTexts(newHandler(tt.config).buildContext(alert, tt.orgID)) {
+				if strings.HasPrefix(text, "Rule:") {
+					got = text
+				}
+			}
+			if got != tt.want {
+				t.Errorf("rule link = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDigestSeverityOrder(t *testing.T) {
+	severityAlert := func(name string, severity string) Alert {
+		alert := testAlert("firing", name)
+		alert.Labels["severity"] = severity
+		return alert
+	}
+	alerts := []Alert{severityAlert("a", "info"), severityAlert("b", "critical"), severityAlert("c", "none"), severityAlert("d", "warning"), severityAlert("e", "critical")}
+	tests := []struct {
+		name  string
+		order StringList
+		want  []string
+	}{
+		{name: "payload order", want: []string{"a", "b", "c", "d", "e"}},
+		{name: "critical first", order: StringList{"critical", "warning", "info"}, want: []string{"b", "e", "d", "a", "c"}},
+		{name: "partial order", order: StringList{"warning"}, want: []string{"d", "a", "b", "c", "e"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			config.DigestLine = true
+			config.SeverityOrder = tt.order
+			h := newHandler(config)
+			messages := h.buildMessages(GrafanaMsg{Alerts: alerts}, "alerts")
+			if len(messages) != 1 {
+				t.Fatalf("messages = %d, want 1", len(messages))
+			}
+			var got []string
+			for _, line := range strings.Split(messages[0].Text, "\n") {
+				got = append(got, strings.TrimPrefix(line, ":sos: "))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("digest order = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildMessagesSummaryThreshold(t *testing.T) {
+	tests := []struct {
+		name    string
+		alerts  int
+		summary bool
+	}{
+		{name: "below", alerts: 4, summary: false},
+		{name: "at", alerts: 5, summary: false},
+		{name: "above", alerts: 6, summary: true},
+	}
+	config := testConfig()
+	config.SummaryThreshold = 5
+	h := newHandler(config)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages := h.buildMessages(GrafanaMsg{Alerts: testAlerts("firing", tt.alerts)}, "alerts")
+			if len(messages) != 1 {
+				t.Fatalf("messages = %d, want 1", len(messages))
+			}
+			if messages[0].Summary != tt.summary {
+				t.Errorf("summary = %v, want %v", messages[0].Summary, tt.summary)
+			}
+			header := messages[0].Blocks.BlockSet[0].(*slack.HeaderBlock).Text.Text
+			if want := fmt.Sprintf("%d firing alerts", tt.alerts); tt.summary && header != want {
+				t.Errorf("header = %q, want %q", header, want)
+			}
+		})
+	}
+
+	// the threshold counts the alerts of the whole notification
+	mixed := append(testAlerts("firing", 3), testAlerts("resolved", 3)...)
+	messages := h.buildMessages(GrafanaMsg{Alerts: mixed}, "alerts")
+	if len(messages) != 2 || !messages[0].Summary || !messages[1].Summary {
+		t.Errorf("messages = %q, want a summary per status", previewTexts(messages))
+	}
+
+	// a threshold of 0 disables summaries
+	disabled := newHandler(testConfig())
+	if messages := disabled.buildMessages(GrafanaMsg{Alerts: testAlerts("firing", 20)}, "alerts"); len(messages) != 3 || messages[0].Summary {
+		t.Errorf("messages = %d, want 3 detailed chunks", len(messages))
+	}
+}
+
+func TestLabelsBlocksRender(t *testing.T) {
+	labels := map[string]string{"alertname": "HighLoad", "instance": "host:9100"}
+	tests := []struct {
+		render string
+		want   string
+	}{
+		{render: "json", want: `[{"type":"section","text":{"type":"mrkdwn","text":"` + "```" + `{\"alertname\": \"HighLoad\", \"instance\": \"host:9100\"}` + "```" + `"}}]`},
+		{render: "keyvalue", want: `[{"type":"section","text":{"type":"mrkdwn","text":"` + "```" + `alertname=HighLoad\ninstance=host:9100` + "```" + `"}}]`},
+		{render: "fields", want: `[{"type":"section","fields":[{"type":"mrkdwn","text":"*alertname*\nHighLoad"},{"type":"mrkdwn","text":"*instance*\nhost:9100"}]}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.render, func(t *testing.T) {
+			config := testConfig()
+			config.LabelRender = tt.render
+			raw, err := json.Marshal(newHandler(config).labelsBlocks(labels))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(raw); got != tt.want {
+				t.Errorf("blocks = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLabelsBlocksFieldsLimit(t *testing.T) {
+	labels := map[string]string{}
+	for i := 0; i < 12; i++ {
+		labels[fmt.Sprintf("label%02d", i)] = "value"
+	}
+	config := testConfig()
+	config.LabelRender = "fields"
+	blocks := newHandler(config).labelsBlocks(labels)
+	if len(blocks) != 2 {
+		t.Fatalf("blocks = %d, want 2 sections", len(blocks))
+	}
+	for i, want := range []int{10, 2} {
+		if got := len(blocks[i].(*slack.SectionBlock).Fields); got != want {
+			t.Errorf("section %d has %d fields, want %d", i, got, want)
+		}
+	}
+}
+
+func blockIDs(msg SlackMsg) []string {
+	var ids []string
+	for _, block := range msg.Blocks.BlockSet {
+		switch block := block.(type) {
+		case *slack.ActionBlock:
+			ids = append(ids, block.BlockID)
+		case *slack.ContextBlock:
+			ids = append(ids, block.BlockID)
+		}
+	}
+	return ids
+}
+
+func TestBlockKeyOfLabelLessAlerts(t *testing.T) {
+	startsAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	labelLess := func(fingerprint string, summary string, startsAt time.Time) Alert {
+		return Alert{Status: "firing", Fingerprint: fingerprint, Annotations: map[string]string{"summary": summary}, StartsAt: startsAt}
+	}
+	tests := []struct {
+		name string
+		a, b Alert
+	}{
+		{name: "fingerprints", a: labelLess("fp-a", "a", startsAt), b: labelLess("fp-b", "a", startsAt)},
+		{name: "annotations", a: labelLess("", "a", startsAt), b: labelLess("", "b", startsAt)},
+		{name: "start times", a: labelLess("", "a", startsAt), b: labelLess("", "a", startsAt.Add(time.Minute))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if blockKey(tt.a) == blockKey(tt.b) {
+				t.Errorf("label-less alerts share the block key %s", blockKey(tt.a))
+			}
+		})
+	}
+
+	// separate messages of label-less alerts carry different block IDs too
+	h := newHandler(testConfig())
+	first := blockIDs(h.buildMessage(GrafanaMsg{}, []Alert{labelLess("fp-a", "a", startsAt)}, "alerts"))
+	second := blockIDs(h.buildMessage(GrafanaMsg{}, []Alert{labelLess("fp-b", "a", startsAt)}, "alerts"))
+	for _, id := range first {
+		if slices.Contains(second, id) {
+			t.Errorf("block ID %s is shared by label-less alerts", id)
+		}
+	}
+}
+
+func TestGeneratorExpr(t *testing.T) {
+	tests := []struct {
+		name         string
+		generatorURL string
+		want         string
+		wantErr      bool
+	}{
+		{name: "unescaped", generatorURL: "http://prometheus:9090/graph?g0.expr=up+%3D%3D+0&g0.tab=1", want: "up == 0"},
+		{name: "escaped as unicode", generatorURL: `http://prometheus:9090/graph?g0.expr=up+%3D%3D+0\u0026g0.tab=1`, want: "up == 0"},
+		{name: "escaped as html", generatorURL: "http://prometheus:9090/graph?g0.expr=up+%3D%3D+0&amp;g0.tab=1", want: "up == 0"},
+		{name: "tab first", generatorURL: `http://prometheus:9090/graph?g0.tab=1\u0026g0.expr=rate%28http_requests_total%5B5m%5D%29`, want: "rate(http_requests_total[5m])"},
+		{name: "without expr", generatorURL: "http://prometheus:9090/graph?g0.tab=1", want: ""},
+		{name: "empty", generatorURL: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := generatorExpr(tt.generatorURL)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("generatorExpr(%q) error = %v, want error %v", tt.generatorURL, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("generatorExpr(%q) = %q, want %q", tt.generatorURL, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildContextFiringDuration(t *testing.T) {
+	resolved := testAlert("resolved", "a")
+	resolved.EndsAt = resolved.StartsAt.Add(2*time.Hour + 15*time.Minute)
+	firing := testAlert("firing", "a")
+	enabled := testConfig()
+	enabled.FiringDuration = true
+	tests := []struct {
+		name   string
+		config Config
+		alert  Alert
+		want   string
+	}{
+		{name: "resolved", config: enabled, alert: resolved, want: "Was firing for 2h 15m"},
+		{name: "firing", config: enabled, alert: firing},
+		{name: "disabled", config: testConfig(), alert: resolved},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			for _, text := range contextTexts(newHandler(tt.config).buildContext(tt.alert, 0)) {
+				if strings.HasPrefix(text, "Was firing") {
+					got = text
+				}
+			}
+			if got != tt.want {
+				t.Errorf("firing duration = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHumanizeDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{d: 0, want: "0s"},
+		{d: 1499 * time.Millisecond, want: "1s"},
+		{d: 90 * time.Second, want: "1m 30s"},
+		{d: time.Hour, want: "1h 0m"},
+		{d: 50*time.Hour + 59*time.Minute, want: "2d 2h"},
+	}
+	for _, tt := range tests {
+		if got := humanizeDuration(tt.d); got != tt.want {
+			t.Errorf("humanizeDuration(%s) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestSeverityColor(t *testing.T) {
+	severityAlert := func(status string, severity string) Alert {
+		alert := testAlert(status, "a")
+		if severity != "" {
+			alert.Labels["severity"] = severity
+		}
+		return alert
+	}
+	tests := []struct {
+		name           string
+		severityColors StringMap
+		severityMap    SeverityMap
+		alert          Alert
+		want           string
+	}{
+		{name: "critical", alert: severityAlert("firing", "critical"), want: "#E01E5A"},
+		{name: "warning", alert: severityAlert("firing", "warning"), want: "#FF9900"},
+		{name: "unknown severity", alert: severityAlert("firing", "info"), want: "#9E9E9E"},
+		{name: "without severity", alert: severityAlert("firing", ""), want: "#9E9E9E"},
+		{name: "resolved", alert: severityAlert("resolved", "critical"), want: "#2EB67D"},
+		{name: "configured tier", severityColors: StringMap{"info": "#0000FF"}, alert: severityAlert("firing", "info"), want: "#0000FF"},
+		{name: "configured over default", severityColors: StringMap{"critical": "#AA0000"}, alert: severityAlert("firing", "critical"), want: "#AA0000"},
+		{name: "severity map", severityMap: SeverityMap{"info": {Color: "#00FF00"}}, alert: severityAlert("firing", "info"), want: "#00FF00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			config.SeverityColors = tt.severityColors
+			config.SeverityMap = tt.severityMap
+			if got := newHandler(config).severityColor(tt.alert); got != tt.want {
+				t.Errorf("color = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	config := testConfig()
+	config.ColorBySeverity = true
+	h := newHandler(config)
+	msg := h.buildMessage(GrafanaMsg{}, []Alert{severityAlert("firing", "critical"), severityAlert("firing", "warning")}, "alerts")
+	if len(msg.Attachments) != 2 || msg.Attachments[0].Color != "#E01E5A" || msg.Attachments[1].Color != "#FF9900" {
+		t.Errorf("attachments = %+v, want one colored attachment per alert", msg.Attachments)
+	}
+}
+
+func TestBuildButtonsOrgID(t *testing.T) {
+	alert := Alert{
+		Status:       "firing",
+		Labels:       map[string]string{"alertname": "HighLoad"},
+		GeneratorURL: "http://prometheus:9090/graph?g0.expr=up&g0.tab=1",
+		SilenceURL:   "https://grafana.example.com/alerting/silence/new?alertmanager=grafana",
+		Annotations:  map[string]string{"runbook_url": "https://wiki.example.com/runbook"},
+	}
+	grafana := testConfig()
+	grafana.DisableGrafanaSilenceButton = false
+	external := grafana
+	external.GrafanaAlertSource = false
+	external.GrafanaUrl = "https://grafana.example.com"
+	tests := []struct {
+		name   string
+		config Config
+		orgID  int64
+		want   map[string]bool
+	}{
+		{name: "org", config: external, orgID: 3, want: map[string]bool{"generator": true, "explore": true, "silence": true, "runbook": false}},
+		{name: "default org", config: external, want: map[string]bool{"generator": false, "explore": false, "silence": false, "runbook": false}},
+		// urls sent by grafana are passed through untouched
+		{name: "grafana alert source", config: grafana, orgID: 3, want: map[string]bool{"generator": false, "silence": false, "runbook": false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			urls := buttonURLs(newHandler(tt.config).buildButtons(alert, tt.orgID))
+			if len(urls) != len(tt.want) {
+				t.Errorf("buttons = %v, want %v", urls, tt.want)
+			}
+			for id, want := range tt.want {
+				parsed, err := url.Parse(urls[id])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := parsed.Query().Get("orgId") == "3"; got != want {
+					t.Errorf("%s url = %s, want orgId=3 %v", id, urls[id], want)
+				}
+			}
+		})
+	}
+}
+
+func TestWithOrgID(t *testing.T) {
+	tests := []struct {
+		link  string
+		orgID int64
+		want  string
+	}{
+		{link: "https://grafana.example.com/alerting/list", orgID: 2, want: "https://grafana.example.com/alerting/list?orgId=2"},
+		{link: "https://grafana.example.com/alerting/list?ruleType=alerting", orgID: 2, want: "https://grafana.example.com/alerting/list?ruleType=alerting&orgId=2"},
+		{link: "https://grafana.example.com/alerting/list", orgID: 0, want: "https://grafana.example.com/alerting/list"},
+	}
+	for _, tt := range tests {
+		if got := withOrgID(tt.link, tt.orgID); got != tt.want {
+			t.Errorf("withOrgID(%q, %d) = %q, want %q", tt.link, tt.orgID, got, tt.want)
+		}
+	}
+}
+
+func TestBuildMessageMergesAnnotationsIntoLabels(t *testing.T) {
+	alert := testAlert("firing", "a")
+	alert.Labels["team"] = "db"
+	alert.Annotations["owner"] = "dba"
+	alert.Annotations["dashboard"] = "https://grafana.example.com/d/abc"
+	tests := []struct {
+		name             string
+		labelAnnotations StringList
+		want             string
+	}{
+		{name: "merged", labelAnnotations: StringList{"owner", "missing"}, want: "```alertname=a\nannotation:owner=dba\nteam=db```"},
+		{name: "not merged", want: "```alertname=a\nteam=db```"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			config.LabelAnnotations = tt.labelAnnotations
+			config.LabelRender = "keyvalue"
+			h := newHandler(config)
+			var labels string
+			for _, block := range h.buildMessage(GrafanaMsg{}, []Alert{alert}, "alerts").Blocks.BlockSet {
+				if section, ok := block.(*slack.SectionBlock); ok && section.Text != nil && strings.HasPrefix(section.Text.Text, "```") {
+					labels = section.Text.Text
+				}
+			}
+			if labels != tt.want {
+				t.Errorf("labels block = %q, want %q", labels, tt.want)
+			}
+		})
+	}
+}
+
+func TestDigestLine(t *testing.T) {
+	config := testConfig()
+	config.DigestLine = true
+	config.SeverityEmoji = StringMap{"critical": ":fire:"}
+	h, webhook := webhookHandler(t, config)
+	critical := testAlert("firing", "disk full")
+	critical.Labels["severity"] = "critical"
+	critical.ValueString = "[ var='B' labels={} value=123456 ]"
+	if w := notify(t, h, "/", GrafanaMsg{Alerts: []Alert{critical, testAlert("firing", "slow"), testAlert("resolved", "down")}}); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+
+	posted := webhook.posted()
+	want := []string{
+		":fire: disk full (123.5k)\n:sos: slow",
+		":large_green_circle: down",
+	}
+	if got := previewTexts(posted); !slices.Equal(got, want) {
+		t.Errorf("texts = %q, want %q", got, want)
+	}
+	for _, msg := range posted {
+		if msg.Blocks != nil || len(msg.Attachments) != 0 {
+			t.Errorf("digest renders blocks or attachments: %s", blocksJSON(t, msg))
+		}
+	}
+}
+
+func TestBlockIDsOfAlertsSharingLabels(t *testing.T) {
+	h := newHandler(testConfig())
+	a, b := testAlert("firing", "HighLoad"), testAlert("firing", "HighLoad")
+	b.Annotations["summary"] = "HighLoad again"
+	b.ValueString = "[ var='B' labels={} value=2 ]"
+
+	ids := blockIDs(h.buildMessage(GrafanaMsg{}, []Alert{a, b}, "alerts"))
+	if len(ids) != 4 {
+		t.Fatalf("block IDs = %q, want an action and a context block per alert", ids)
+	}
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if seen[id] {
+			t.Errorf("block ID %s is used twice in %q", id, ids)
+		}
+		seen[id] = true
+	}
+}
+
+func TestDrainAndShutdown(t *testing.T) {
+	config, err := loadTestConfig(t, "-dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := newReloader(newHandler(config), "", nil)
+	handler.current().ready.Store(true)
+	server := httptest.NewServer(newMux(config, handler))
+	defer server.Close()
+	readyz := func() (int, error) {
+		res, err := http.Get(server.URL + "/readyz")
+		if err != nil {
+			return 0, err
+		}
+		defer res.Body.Close()
+		return res.StatusCode, nil
+	}
+	if code, err := readyz(); err != nil || code != http.StatusOK {
+		t.Fatalf("readyz = %d, %v before shutdown, want 200", code, err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- drainAndShutdown(context.Background(), server.Config, handler, 300*time.Millisecond)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if code, err := readyz(); err != nil || code != http.StatusServiceUnavailable {
+		t.Errorf("readyz = %d, %v during the delay, want 503 while still serving", code, err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readyz(); err == nil {
+		t.Error("readyz still served after shutdown")
+	}
+}
+
+func TestWebhookBodyTooLarge(t *testing.T) {
+	config := testConfig()
+	config.MaxBodyBytes = 64
+	h, webhook := webhookHandler(t, config)
+	w := notify(t, h, "/", GrafanaMsg{Alerts: testAlerts("firing", 3)})
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want 413", w.Code)
+	}
+	if posted := webhook.posted(); len(posted) != 0 {
+		t.Errorf("posted %d messages, want none", len(posted))
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	body, err := json.Marshal(GrafanaMsg{Alerts: []Alert{testAlert("firing", "a")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(secret string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	tests := []struct {
+		name      string
+		secret    string
+		header    string
+		sentIn    string
+		signature string
+		want      int
+	}{
+		{name: "valid", secret: "secret", header: "X-Grafana-Signature", sentIn: "X-Grafana-Signature", signature: sign("secret"), want: http.StatusOK},
+		{name: "wrong secret", secret: "secret", header: "X-Grafana-Signature", sentIn: "X-Grafana-Signature", signature: sign("other"), want: http.StatusUnauthorized},
+		{name: "not hex", secret: "secret", header: "X-Grafana-Signature", sentIn: "X-Grafana-Signature", signature: "not-hex", want: http.StatusUnauthorized},
+		{name: "missing", secret: "secret", header: "X-Grafana-Signature", want: http.StatusUnauthorized},
+		{name: "no secret skips the check", secret: "", header: "X-Grafana-Signature", want: http.StatusOK},
+		{name: "custom header", secret: "secret", header: "X-Signature", sentIn: "X-Signature", signature: sign("secret"), want: http.StatusOK},
+		{name: "default header with custom one configured", secret: "secret", header: "X-Signature", sentIn: "X-Grafana-Signature", signature: sign("secret"), want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			config.HmacSecret = tt.secret
+			config.HmacHeader = tt.header
+			h, webhook := webhookHandler(t, config)
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+			if tt.sentIn != "" {
+				req.Header.Set(tt.sentIn, tt.signature)
+			}
+			w := httptest.NewRecorder()
+			h.handleWebhookRequest(w, req)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if posted := len(webhook.posted()) > 0; posted != (tt.want == http.StatusOK) {
+				t.Errorf("posted = %v with status %d", posted, w.Code)
+			}
+		})
+	}
+}
+
+func TestBuildMessagesGroupByLabel(t *testing.T) {
+	config := testConfig()
+	config.GroupBy = "team"
+	h := newHandler(config)
+	teamAlert := func(status string, name string, team string) Alert {
+		alert := testAlert(status, name)
+		if team != "" {
+			alert.Labels["team"] = team
+		}
+		return alert
+	}
+	alerts := []Alert{
+		teamAlert("firing", "a", "web"),
+		teamAlert("firing", "b", ""),
+		teamAlert("firing", "c", "db"),
+		teamAlert("resolved", "d", "db"),
+	}
+
+	messages := h.buildMessages(GrafanaMsg{Alerts: alerts}, "alerts")
+	var groups []string
+	var names [][]string
+	for _, msg := range messages {
+		groups = append(groups, msg.Group)
+		var alertNames []string
+		for _, alert := range msg.Alerts {
+			alertNames = append(alertNames, alert.Labels["alertname"])
+		}
+		names = append(names, alertNames)
+	}
+	if want := []string{"db", "web", ""}; !slices.Equal(groups, want) {
+		t.Fatalf("groups = %q, want one message per team and the alerts without it last", groups)
+	}
+	want := [][]string{{"c", "d"}, {"a"}, {"b"}}
+	for i := range want {
+		if !slices.Equal(names[i], want[i]) {
+			t.Errorf("%q group has alerts %q, want %q", groups[i], names[i], want[i])
+		}
+	}
+}
+
+func TestHideLabels(t *testing.T) {
+	config := testConfig()
+	config.HideLabels = StringList{"__*", "pod"}
+	config.LabelRender = "keyvalue"
+	h := newHandler(config)
+	alert := testAlert("firing", "a")
+	alert.Labels["__alert_rule_uid__"] = "fe1x2y"
+	alert.Labels["pod"] = "api-7d9f"
+	alert.Labels["pod_ip"] = "10.0.0.1"
+
+	blocks := blocksJSON(t, h.buildMessage(GrafanaMsg{}, []Alert{alert}, "alerts"))
+	for _, hidden := range []string{"__alert_rule_uid__", "pod=api-7d9f"} {
+		if strings.Contains(blocks, hidden) {
+			t.Errorf("labels block renders hidden %s: %s", hidden, blocks)
+		}
+	}
+	for _, shown := range []string{"alertname=a", "pod_ip=10.0.0.1"} {
+		if !strings.Contains(blocks, shown) {
+			t.Errorf("labels block misses %s: %s", shown, blocks)
+		}
+	}
+}
+
+func TestPathPrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		path   string
+		want   int
+	}{
+		{prefix: "/alerter/", path: "/alerter/slack", want: http.StatusOK},
+		{prefix: "/alerter/", path: "/alerter/healthz", want: http.StatusOK},
+		{prefix: "/alerter/", path: "/slack", want: http.StatusNotFound},
+		{prefix: "/alerter/", path: "/healthz", want: http.StatusNotFound},
+		{prefix: "alerter", path: "/alerter/readyz", want: http.StatusOK},
+		{prefix: "/", path: "/slack", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix+tt.path, func(t *testing.T) {
+			config := testConfig()
+			config.PathPrefix = tt.prefix
+			config.DryRun = true
+			handler := newReloader(newHandler(config), "", nil)
+			handler.current().ready.Store(true)
+			body, err := json.Marshal(GrafanaMsg{Alerts: []Alert{testAlert("firing", "a")}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := httptest.NewRecorder()
+			newMux(config, handler).ServeHTTP(w, httptest.NewRequest(http.MethodPost, tt.path, bytes.NewReader(body)))
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// slackProbe caches the result of the last slack connectivity check so that
+// readiness probes reach slack at most once per interval.
+type slackProbe struct {
+	mu        sync.Mutex
+	interval  time.Duration
+	checkedAt time.Time
+	err       error
+	client    *http.Client
+}
+
+func newSlackProbe(interval time.Duration) *slackProbe {
+	// a dedicated transport keeps the expected 400 responses of webhook
+	// checks out of the LoggingRoundTripper logs
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	return &slackProbe{interval: interval, client: &http.Client{Transport: transport, Timeout: 10 * time.Second}}
+}
+
+func (h *Handler) handleReady(w http.ResponseWriter, r *http.Request) {
+	if !h.ready.Load() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	h.probe.mu.Lock()
+	defer h.probe.mu.Unlock()
+	if time.Since(h.probe.checkedAt) >= h.probe.interval {
+		h.probe.err = h.checkSlack(r.Context())
+		h.probe.checkedAt = time.Now()
+		if h.probe.err != nil {
+			slog.Warn("slack is not reachable", "err", h.probe.err)
+		}
+	}
+	if h.probe.err != nil {
+		http.Error(w, h.probe.err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// checkSlack validates the credentials without posting anything: the bot token
+// with auth.test and every webhook with an empty message, which slack rejects
+// with no_text only when the webhook itself is valid.
+func (h *Handler) checkSlack(ctx context.Context) error {
+	if h.config.DryRun {
+		return nil
+	}
+	if h.slackClient != nil {
+		_, err := h.slackClient.AuthTestContext(ctx)
+		return err
+	}
+	if h.config.WebhookUrl != "" {
+		if err := h.checkWebhook(ctx, h.config.WebhookUrl); err != nil {
+			return err
+		}
+	}
+	for name, webhookUrl := range h.config.WebhookUrls {
+		if err := h.checkWebhook(ctx, webhookUrl); err != nil {
+			return fmt.Errorf("'%s' webhook: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (h *Handler) checkWebhook(ctx context.Context, webhookUrl string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookUrl, strings.NewReader("{}"))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := h.probe.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	if res.StatusCode == http.StatusBadRequest && strings.TrimSpace(string(body)) == "no_text" {
+		return nil
+	}
+	return fmt.Errorf("slack webhook responded with %s: %s", res.Status, body)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/sha256"
+	"flag"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// reloader serves every request with the handler of the latest valid config,
+// requests in flight keep the handler they started with.
+type reloader struct {
+	handler    atomic.Pointer[Handler]
+	configFile string
+	args       []string
+}
+
+func newReloader(handler *Handler, configFile string, args []string) *reloader {
+	r := &reloader{configFile: configFile, args: args}
+	r.handler.Store(handler)
+	return r
+}
+
+func (r *reloader) current() *Handler {
+	return r.handler.Load()
+}
+
+// handle routes a request to the given method of the current handler.
+func (r *reloader) handle(f func(*Handler, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		f(r.current(), w, req)
+	}
+}
+
+// watch reloads the config on SIGHUP and whenever the content of the config
+// files changes. ConfigMap updates swap symlinks, so the content is compared
+// instead of modification times.
+func (r *reloader) watch(interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	var tick <-chan time.Time
+	if interval > 0 && slices.ContainsFunc(r.paths(), func(path string) bool { return path != "" }) {
+		tick = time.NewTicker(interval).C
+	}
+	sum := r.checksum()
+	for {
+		select {
+		case <-hup:
+			slog.Info("received SIGHUP, reloading config")
+		case <-tick:
+			if r.checksum() == sum {
+				continue
+			}
+			slog.Info("config file changed, reloading config")
+		}
+		sum = r.checksum()
+		r.reload()
+	}
+}
+
+// checksum hashes the config, routes and severity map files, unreadable files
+// are skipped so that the failing reload is only attempted once.
+func (r *reloader) checksum() [sha256.Size]byte {
+	digest := sha256.New()
+	for _, path := range r.paths() {
+		if path == "" {
+			continue
+		}
+		if data, err := os.ReadFile(path); err == nil {
+			digest.Write(data)
+		}
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], digest.Sum(nil))
+	return sum
+}
+
+// paths lists the watched files, unset ones are empty.
+func (r *reloader) paths() []string {
+	current := r.current().config
+	return []string{r.configFile, current.RoutesFile, current.SeverityMapFile}
+}
+
+// reload parses and validates the config again and swaps the handler, an
+// invalid config is logged and the current one is kept.
+func (r *reloader) reload() {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	config, _, err := parseConfig(fs, r.args)
+	if err == nil {
+		err = validateConfig(&config)
+	}
+	if err != nil {
+		slog.Error("failed to reload config, keeping the current one", "err", err)
+		return
+	}
+	logger, err := newLogger(config.LogFormat, config.LogLevel)
+	if err != nil {
+		slog.Error("failed to reload config, keeping the current one", "err", err)
+		return
+	}
+	slog.SetDefault(logger)
+
+	prev := r.current()
+	if restartRequired(prev.config, config) {
+		slog.Warn("listen address, path prefix, tls, timeouts and snooze settings only change after a restart")
+	}
+	r.handler.Store(prev.reloaded(config))
+	slog.Info("config reloaded")
+}
+
+// restartRequired reports whether settings of the http server changed, the
+// server is set up once so these need a restart to take effect.
+func restartRequired(prev Config, next Config) bool {
+	return prev.ListenAddress != next.ListenAddress ||
+		normalizePathPrefix(prev.PathPrefix) != normalizePathPrefix(next.PathPrefix) ||
+		prev.TLSCert != next.TLSCert || prev.TLSKey != next.TLSKey ||
+		prev.ReadTimeout != next.ReadTimeout || prev.WriteTimeout != next.WriteTimeout ||
+		prev.IdleTimeout != next.IdleTimeout ||
+		(prev.SnoozeAlertmanagerUrl == "") != (next.SnoozeAlertmanagerUrl == "") ||
+		prev.DebugHTTP != next.DebugHTTP
+}
+
+// reloaded returns a handler for the new config that carries over the state of
+// h, so dedup caches, posted messages, limits and slack backoff survive reloads
+// as long as their settings did not change.
+func (h *Handler) reloaded(config Config) *Handler {
+	next := newHandler(config)
+	if config.ResolvedDedupWindow == h.config.ResolvedDedupWindow {
+		next.resolved = h.resolved
+	}
+	if config.DedupWindow == h.config.DedupWindow {
+		next.firing = h.firing
+	}
+	if config.MaxConcurrent == h.config.MaxConcurrent {
+		next.inflight = h.inflight
+	}
+	if config.SlackRateLimit == h.config.SlackRateLimit {
+		next.limiter = h.limiter
+	}
+	next.messages = h.messages
+	next.pausedUntil.Store(h.pausedUntil.Load())
+	next.ready.Store(h.ready.Load())
+	return next
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchReloadsRoutesFileWithoutConfigFile(t *testing.T) {
+	routesFile := filepath.Join(t.TempDir(), "routes.yaml")
+	if err := os.WriteFile(routesFile, []byte("- channel: '#before'\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"-dry-run", "-routes", routesFile}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	config, configFile, err := parseConfig(fs, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validateConfig(&config); err != nil {
+		t.Fatal(err)
+	}
+	r := newReloader(newHandler(config), configFile, args)
+	go r.watch(10 * time.Millisecond)
+
+	// give watch the time to take the initial checksum
+	time.Sleep(50 * time.Millisecond)
+	if err := os.WriteFile(routesFile, []byte("- channel: '#after'\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for r.current().config.Routes[0].Channel != "#after" {
+		if time.Now().After(deadline) {
+			t.Fatal("routes file change was not picked up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// retryBudget caps the total time the messages of a single notification spend
+// waiting for retries, so one notification cannot hold slack for the others.
+type retryBudget struct {
+	mu        sync.Mutex
+	remaining time.Duration
+}
+
+// take reserves the wait from the budget, a wait that does not fit fails fast
+// and an empty budget allows no retry at all, not even an immediate one.
+func (b *retryBudget) take(wait time.Duration) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.remaining <= 0 || wait > b.remaining {
+		return false
+	}
+	b.remaining -= wait
+	return true
+}
+
+func (h *Handler) postToSlackWithRetry(ctx context.Context, budget *retryBudget, grafanaMsg GrafanaMsg, msg *SlackMsg) error {
+	backoff := time.Second
+	for {
+		err := h.postToSlack(ctx, grafanaMsg, msg)
+		if err == nil {
+			return nil
+		}
+		wait, ok := retryDelay(err, backoff)
+		if !ok || !budget.take(wait) {
+			return err
+		}
+		slog.Warn("retrying slack post", "err", err, "channel", msg.Channel, "in", wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// retryDelay reports whether the failed post is worth retrying and how long to
+// wait before, slack tells the wait of rate limited requests itself. A rate
+// limit without a positive wait falls back to defaultRetryAfter, retrying it
+// right away would only hammer slack.
+func retryDelay(err error, backoff time.Duration) (time.Duration, bool) {
+	var rateLimited *slack.RateLimitedError
+	if errors.As(err, &rateLimited) {
+		if rateLimited.RetryAfter <= 0 {
+			return defaultRetryAfter, true
+		}
+		return rateLimited.RetryAfter, true
+	}
+	var statusErr slack.StatusCodeError
+	if errors.As(err, &statusErr) {
+		return backoff, statusErr.Code >= 500
+	}
+	var urlErr *url.Error
+	return backoff, errors.As(err, &urlErr)
+}
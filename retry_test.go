@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantWait  time.Duration
+		wantRetry bool
+	}{
+		{name: "rate limited", err: &slack.RateLimitedError{RetryAfter: 7 * time.Second}, wantWait: 7 * time.Second, wantRetry: true},
+		{name: "rate limited without wait", err: &slack.RateLimitedError{}, wantWait: defaultRetryAfter, wantRetry: true},
+		{name: "server error", err: slack.StatusCodeError{Code: http.StatusBadGateway}, wantWait: 2 * time.Second, wantRetry: true},
+		{name: "client error", err: slack.StatusCodeError{Code: http.StatusNotFound}, wantWait: 2 * time.Second, wantRetry: false},
+		{name: "network error", err: &url.Error{Op: "Post", URL: "https://hooks.slack.com", Err: errors.New("connection refused")}, wantWait: 2 * time.Second, wantRetry: true},
+		{name: "other error", err: errors.New("invalid_blocks"), wantWait: 2 * time.Second, wantRetry: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wait, retry := retryDelay(tt.err, 2*time.Second)
+			if wait != tt.wantWait || retry != tt.wantRetry {
+				t.Errorf("retryDelay = %s, %v, want %s, %v", wait, retry, tt.wantWait, tt.wantRetry)
+			}
+		})
+	}
+}
+
+func TestRetryBudgetCapsRetries(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	// backoff waits 1s, 2s, ... so a budget of 1.5s allows a single retry in total
+	config := testConfig()
+	config.RetryBudget = 1500 * time.Millisecond
+	h := newHandler(config)
+	msgs := h.buildMessages(GrafanaMsg{Alerts: append(testAlerts("firing", 1), testAlert("resolved", "r"))}, "alerts")
+	for i := range msgs {
+		msgs[i].WebhookUrl = server.URL
+	}
+	start := time.Now()
+	err := h.postAll(context.Background(), GrafanaMsg{}, msgs, "alerts")
+	elapsed := time.Since(start)
+
+	if err == nil {
+		t.Fatal("want the posts to fail")
+	}
+	if got := attempts.Load(); got != 3 {
+		t.Errorf("attempts = %d, want one retry for both messages together", got)
+	}
+	if elapsed > 1500*time.Millisecond {
+		t.Errorf("posting took %s, want at most the budget", elapsed)
+	}
+}
+
+func TestRetryBudgetDisabled(t *testing.T) {
+	var budget *retryBudget
+	if budget.take(time.Millisecond) {
+		t.Error("nil budget allows a retry")
+	}
+	budget = &retryBudget{}
+	if budget.take(0) {
+		t.Error("empty budget allows an immediate retry")
+	}
+	budget = &retryBudget{remaining: time.Second}
+	if !budget.take(time.Second) || budget.take(time.Nanosecond) {
+		t.Error("budget does not allow exactly its remaining time")
+	}
+}
+
+func TestWorkspaceBackoffPausesPosts(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		posted []time.Time
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		posted = append(posted, time.Now())
+		if len(posted) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	h := newHandler(testConfig())
+	msgs := h.buildMessages(GrafanaMsg{Alerts: []Alert{testAlert("firing", "a"), testAlert("resolved", "b")}}, "alerts")
+	for i := range msgs {
+		msgs[i].WebhookUrl = server.URL
+	}
+	err := h.postAll(context.Background(), GrafanaMsg{}, msgs, "alerts")
+	var rateLimited *slack.RateLimitedError
+	if !errors.As(err, &rateLimited) {
+		t.Fatalf("err = %v, want the rate limit of the first post", err)
+	}
+
+	if len(posted) != 2 {
+		t.Fatalf("posts = %d, want 2", len(posted))
+	}
+	if pause := posted[1].Sub(posted[0]); pause < time.Second {
+		t.Errorf("second post followed after %s, want a pause of the Retry-After", pause)
+	}
+}
+
+func TestBackOff(t *testing.T) {
+	rateLimited := &slack.RateLimitedError{RetryAfter: 200 * time.Millisecond}
+	tests := []struct {
+		name      string
+		backoff   bool
+		err       error
+		wantPause bool
+	}{
+		{name: "rate limited", backoff: true, err: rateLimited, wantPause: true},
+		{name: "disabled", backoff: false, err: rateLimited, wantPause: false},
+		{name: "other error", backoff: true, err: errors.New("invalid_blocks"), wantPause: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			config.WorkspaceBackoff = tt.backoff
+			h := newHandler(config)
+			h.backOff(tt.err)
+			start := time.Now()
+			if err := h.throttle(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if paused := time.Since(start) >= 150*time.Millisecond; paused != tt.wantPause {
+				t.Errorf("throttle waited %s, want a pause %v", time.Since(start), tt.wantPause)
+			}
+		})
+	}
+
+	// a shorter Retry-After does not cut an ongoing pause short
+	h := newHandler(testConfig())
+	h.backOff(&slack.RateLimitedError{RetryAfter: time.Hour})
+	h.backOff(&slack.RateLimitedError{RetryAfter: time.Millisecond})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := h.throttle(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("throttle = %v, want to wait for the longer pause", err)
+	}
+}
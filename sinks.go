@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// postToSinks delivers the message to every sink url as JSON, so other systems
+// receive exactly what is posted to slack. Failures of all sinks are joined.
+func (h *Handler) postToSinks(ctx context.Context, msg *SlackMsg) error {
+	var errs []error
+	for _, sinkUrl := range h.config.SinkUrls {
+		if err := h.postToSink(ctx, sinkUrl, msg); err != nil {
+			// sink urls may carry credentials, only the host is reported
+			host := sinkUrl
+			if parsed, err := url.Parse(sinkUrl); err == nil {
+				host = parsed.Host
+			}
+			errs = append(errs, fmt.Errorf("sink %s: %w", host, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (h *Handler) postToSink(ctx context.Context, sinkUrl string, msg *SlackMsg) error {
+	ctx, cancel := h.slackContext(ctx)
+	defer cancel()
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sinkUrl, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := apiClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("sink responded with %s", res.Status)
+	}
+	return nil
+}
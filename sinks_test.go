@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPostToSinksDeliversToEverySink(t *testing.T) {
+	var received []string
+	sink := func(status int) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var msg SlackMsg
+			if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+				t.Errorf("sink received invalid message: %v", err)
+			}
+			received = append(received, msg.Text)
+			w.WriteHeader(status)
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	accepted, noContent := sink(http.StatusAccepted), sink(http.StatusNoContent)
+
+	config := testConfig()
+	config.SinkUrls = StringList{accepted.URL, noContent.URL}
+	h := newHandler(config)
+	msg := SlackMsg{Alerts: []Alert{testAlert("firing", "a")}}
+	msg.Text = "Fired: [a] "
+	if err := h.postToSinks(context.Background(), &msg); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(received) != 2 || received[0] != msg.Text || received[1] != msg.Text {
+		t.Errorf("sinks received %q, want the message in both", received)
+	}
+	// main wraps the default transport to log slack responses, sinks must not use it
+	if _, ok := apiClient.Transport.(*http.Transport); !ok {
+		t.Errorf("api client transport = %T, want a transport of its own", apiClient.Transport)
+	}
+}
+
+func TestPostToSinksHidesSinkCredentials(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	config := testConfig()
+	config.SinkUrls = StringList{strings.Replace(srv.URL, "http://", "http://user:secret@", 1) + "/token"}
+	h := newHandler(config)
+	err := h.postToSinks(context.Background(), &SlackMsg{})
+	if err == nil {
+		t.Fatal("want an error for a failing sink")
+	}
+	if strings.Contains(err.Error(), "secret") || strings.Contains(err.Error(), "token") {
+		t.Errorf("error leaks the sink url: %v", err)
+	}
+}
+
+func TestNotificationReachesSlackAndSinks(t *testing.T) {
+	sinkWebhook := newFakeWebhook(t)
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	config := testConfig()
+	config.SinkUrls = StringList{sinkWebhook.URL}
+	h, slackWebhook := webhookHandler(t, config)
+	if w := notify(t, h, "/", GrafanaMsg{Alerts: []Alert{testAlert("firing", "a")}}); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if len(slackWebhook.posted()) != 1 || len(sinkWebhook.posted()) != 1 {
+		t.Errorf("slack got %d and the sink %d messages, want 1 each", len(slackWebhook.posted()), len(sinkWebhook.posted()))
+	}
+
+	// a failing sink is only logged, grafana would send the notification again
+	// and duplicate the message slack already accepted
+	config = testConfig()
+	config.SinkUrls = StringList{failing.URL, sinkWebhook.URL}
+	config.DedupWindow = time.Hour
+	h, slackWebhook = webhookHandler(t, config)
+	if w := notify(t, h, "/", GrafanaMsg{Alerts: []Alert{testAlert("firing", "b")}}); w.Code != http.StatusOK {
+		t.Errorf("status = %d with %q, want 200 once slack accepted the message", w.Code, w.Body.String())
+	}
+	if len(slackWebhook.posted()) != 1 || len(sinkWebhook.posted()) != 2 {
+		t.Errorf("slack got %d and the sink %d messages, want the other deliveries to go through", len(slackWebhook.posted()), len(sinkWebhook.posted()))
+	}
+	// the posted alert is recorded for deduplication despite the failing sink
+	notify(t, h, "/", GrafanaMsg{Alerts: []Alert{testAlert("firing", "b")}})
+	if len(slackWebhook.posted()) != 1 {
+		t.Errorf("slack got %d messages, want the repeated alert deduplicated", len(slackWebhook.posted()))
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// slack rejects button values longer than this
+const maxButtonValueLength = 2000
+
+type silence struct {
+	Matchers  []silenceMatcher `json:"matchers"`
+	StartsAt  time.Time        `json:"startsAt"`
+	EndsAt    time.Time        `json:"endsAt"`
+	CreatedBy string           `json:"createdBy"`
+	Comment   string           `json:"comment"`
+}
+
+type silenceMatcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"isRegex"`
+	IsEqual bool   `json:"isEqual"`
+}
+
+func (h *Handler) snoozeButton(alert Alert) *slack.ButtonBlockElement {
+	labels, err := json.Marshal(alert.Labels)
+	if err != nil || len(labels) > maxButtonValueLength {
+		slog.Warn("cannot fit alert labels into snooze button", "err", err, "length", len(labels))
+		return nil
+	}
+	text := fmt.Sprintf(":zzz: Snooze %s", h.config.SnoozeDuration)
+	return slack.NewButtonBlockElement("snooze", string(labels), slack.NewTextBlockObject("plain_text", text, true, false))
+}
+
+// handleInteraction receives slack interactive actions, a click on the snooze
+// button creates a silence for the alert labels and confirms it in the thread.
+func (h *Handler) handleInteraction(w http.ResponseWriter, r *http.Request) {
+	// the body is read before its signature is verified, the limit keeps
+	// unsigned requests from exhausting memory
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.config.MaxBodyBytes))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		slog.Warn("request body is too large", "limit", tooLarge.Limit)
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to read request body", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	verifier, err := slack.NewSecretsVerifier(r.Header, h.config.SlackSigningSecret)
+	if err == nil {
+		_, _ = verifier.Write(body)
+		err = verifier.Ensure()
+	}
+	if err != nil {
+		slog.Warn("invalid slack interaction signature", "err", err)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var callback slack.InteractionCallback
+	if err := json.Unmarshal([]byte(form.Get("payload")), &callback); err != nil {
+		slog.Error("failed to parse slack interaction", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, action := range callback.ActionCallback.BlockActions {
+		if action.ActionID != "snooze" {
+			continue
+		}
+		var labels map[string]string
+		if err := json.Unmarshal([]byte(action.Value), &labels); err != nil {
+			slog.Error("failed to parse snooze labels", "err", err, "value", action.Value)
+			continue
+		}
+		text := fmt.Sprintf(":zzz: <@%s> snoozed the alert for %s", callback.User.ID, h.config.SnoozeDuration)
+		if err := h.createSilence(r.Context(), labels, callback.User.Name); err != nil {
+			slog.Error("failed to create silence", "err", err, "labels", labels)
+			text = fmt.Sprintf(":x: Failed to snooze the alert: %s", err)
+		}
+		reply := &slack.WebhookMessage{
+			Text:            text,
+			ResponseType:    slack.ResponseTypeInChannel,
+			ThreadTimestamp: callback.Container.MessageTs,
+		}
+		if err := slack.PostWebhookContext(r.Context(), callback.ResponseURL, reply); err != nil {
+			slog.Error("failed to confirm snooze", "err", err)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) createSilence(ctx context.Context, labels map[string]string, createdBy string) error {
+	now := time.Now()
+	s := silence{
+		StartsAt:  now,
+		EndsAt:    now.Add(h.config.SnoozeDuration),
+		CreatedBy: createdBy,
+		Comment:   "Snoozed from slack",
+	}
+	for name, value := range labels {
+		s.Matchers = append(s.Matchers, silenceMatcher{Name: name, Value: value, IsEqual: true})
+	}
+	payload, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.config.SnoozeAlertmanagerUrl+"/api/v2/silences", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.config.SnoozeApiToken != "" {
+		req.Header.Set("Authorization", "Bearer "+h.config.SnoozeApiToken)
+	}
+	res, err := apiClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("alertmanager responded with %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// snoozeRequest builds a slack interaction request clicking the snooze button
+// of an alert with the given labels, signed with secret.
+func snoozeRequest(t *testing.T, secret string, responseURL string, labels map[string]string) *http.Request {
+	t.Helper()
+	value, err := json.Marshal(labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(map[string]any{
+		"type":         "block_actions",
+		"user":         map[string]string{"id": "U123", "name": "jane"},
+		"response_url": responseURL,
+		"container":    map[string]string{"message_ts": "1700000000.000100"},
+		"actions":      []map[string]string{{"action_id": "snooze", "block_id": "actions-1-0", "value": string(value)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := url.Values{"payload": {string(payload)}}.Encode()
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = fmt.Fprintf(mac, "v0:%s:%s", timestamp, body)
+
+	req := httptest.NewRequest(http.MethodPost, "/interactions", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func TestHandleInteractionSnoozesAlert(t *testing.T) {
+	tests := []struct {
+		name          string
+		signingSecret string
+		amStatus      int
+		wantCode      int
+		wantSilence   bool
+		wantReply     string
+	}{
+		{
+			name:          "snooze",
+			signingSecret: "secret",
+			amStatus:      http.StatusOK,
+			wantCode:      http.StatusOK,
+			wantSilence:   true,
+			wantReply:     ":zzz: <@U123> snoozed the alert for 1h0m0s",
+		},
+		{
+			name:          "alertmanager failure",
+			signingSecret: "secret",
+			amStatus:      http.StatusBadRequest,
+			wantCode:      http.StatusOK,
+			wantSilence:   true,
+			wantReply:     ":x: Failed to snooze the alert: alertmanager responded with 400 Bad Request",
+		},
+		{
+			name:          "invalid signature",
+			signingSecret: "other",
+			amStatus:      http.StatusOK,
+			wantCode:      http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var silences []silence
+			var authorization string
+			alertmanager := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/api/v2/silences" {
+					t.Errorf("silence posted to %s", r.URL.Path)
+				}
+				authorization = r.Header.Get("Authorization")
+				var s silence
+				if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+					t.Errorf("invalid silence: %v", err)
+				}
+				silences = append(silences, s)
+				w.WriteHeader(tt.amStatus)
+			}))
+			defer alertmanager.Close()
+			var replies []slack.WebhookMessage
+			responses := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var reply slack.WebhookMessage
+				if err := json.NewDecoder(r.Body).Decode(&reply); err != nil {
+					t.Errorf("invalid reply: %v", err)
+				}
+				replies = append(replies, reply)
+			}))
+			defer responses.Close()
+
+			config := testConfig()
+			config.SnoozeAlertmanagerUrl = alertmanager.URL
+			config.SnoozeApiToken = "token"
+			config.SlackSigningSecret = "secret"
+			h := newHandler(config)
+			w := httptest.NewRecorder()
+			h.handleInteraction(w, snoozeRequest(t, tt.signingSecret, responses.URL, map[string]string{"alertname": "HighLoad"}))
+
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			if !tt.wantSilence {
+				if len(silences) != 0 || len(replies) != 0 {
+					t.Errorf("silences = %v, replies = %v, want none", silences, replies)
+				}
+				return
+			}
+			if len(silences) != 1 {
+				t.Fatalf("silences = %d, want 1", len(silences))
+			}
+			s := silences[0]
+			if want := []silenceMatcher{{Name: "alertname", Value: "HighLoad", IsEqual: true}}; len(s.Matchers) != 1 || s.Matchers[0] != want[0] {
+				t.Errorf("matchers = %+v, want %+v", s.Matchers, want)
+			}
+			if d := s.EndsAt.Sub(s.StartsAt); d != time.Hour {
+				t.Errorf("silence lasts %s, want 1h", d)
+			}
+			if s.CreatedBy != "jane" || authorization != "Bearer token" {
+				t.Errorf("silence created by %q with %q, want jane with the api token", s.CreatedBy, authorization)
+			}
+			if len(replies) != 1 {
+				t.Fatalf("replies = %d, want 1", len(replies))
+			}
+			if replies[0].Text != tt.wantReply || replies[0].ThreadTimestamp != "1700000000.000100" {
+				t.Errorf("reply = %q in thread %q, want %q in the alert thread", replies[0].Text, replies[0].ThreadTimestamp, tt.wantReply)
+			}
+		})
+	}
+}
+
+func TestHandleInteractionBodyTooLarge(t *testing.T) {
+	config := testConfig()
+	config.SlackSigningSecret = "secret"
+	config.MaxBodyBytes = 64
+	h := newHandler(config)
+	w := httptest.NewRecorder()
+	h.handleInteraction(w, snoozeRequest(t, "secret", "http://localhost", map[string]string{"alertname": strings.Repeat("x", 64)}))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want 413", w.Code)
+	}
+}
+
+func TestSnoozeButton(t *testing.T) {
+	config := testConfig()
+	config.SnoozeDuration = 30 * time.Minute
+	h := newHandler(config)
+	button := h.snoozeButton(Alert{Labels: map[string]string{"alertname": "HighLoad"}})
+	if button == nil || button.Value != `{"alertname":"HighLoad"}` || button.Text.Text != ":zzz: Snooze 30m0s" {
+		t.Errorf("snooze button = %+v, want the labels as value", button)
+	}
+	huge := Alert{Labels: map[string]string{"alertname": strings.Repeat("x", maxButtonValueLength)}}
+	if button := h.snoozeButton(huge); button != nil {
+		t.Errorf("snooze button = %+v, want none for labels beyond the value limit", button)
+	}
+}